@@ -22,6 +22,7 @@ func runAnalyze(args []string) error {
 	coverDir := fs.String("coverdir", "", "GOCOVERDIR path (mutually exclusive with -profile)")
 	recursive := fs.Bool("r", false, "recursively search -coverdir for coverage data")
 	pkgFilter := fs.String("pkg", "", "package filter (comma-separated import path prefixes)")
+	labelFilter := fs.String("labels", "", "only include flushes whose labels match (comma-separated name=value)")
 	threshold := fs.Float64("threshold", 100, "show functions with coverage below this percentage")
 	minStmts := fs.Int("min-statements", 0, "show functions with at least N unreached statements")
 	outputFile := fs.String("o", "", "output file (default: stdout)")
@@ -40,6 +41,14 @@ func runAnalyze(args []string) error {
 		prefixes = strings.Split(*pkgFilter, ",")
 	}
 
+	sel, err := covparse.ParseLabelSelector(*labelFilter)
+	if err != nil {
+		return err
+	}
+	if len(sel) > 0 && *profilePath != "" {
+		return fmt.Errorf("-labels requires -coverdir")
+	}
+
 	opts := analysis.Options{
 		PkgPrefixes:   prefixes,
 		Threshold:     *threshold,
@@ -47,37 +56,36 @@ func runAnalyze(args []string) error {
 	}
 
 	var rpt *report.Report
-	var err error
 
 	switch {
 	case *recursive:
-		groups, parseErr := covparse.ParseDirRecursiveGrouped(*coverDir)
+		groups, parseErr := covparse.ParseDirRecursiveGrouped(*coverDir, sel)
 		if parseErr != nil {
 			return parseErr
 		}
 		if len(groups) == 1 {
-			text, textErr := groups[0].ParseProfile()
-			if textErr != nil {
-				return textErr
+			profiles, profErr := groups[0].Profiles()
+			if profErr != nil {
+				return profErr
 			}
-			rpt, err = analyzeProfileText(text, opts)
+			rpt, err = analysis.Run(profiles, opts)
 		} else {
 			// Newest build (last element) gets full AST analysis.
 			newest := groups[len(groups)-1]
-			newestText, textErr := newest.ParseProfile()
-			if textErr != nil {
-				return textErr
+			newestProfiles, profErr := newest.Profiles()
+			if profErr != nil {
+				return profErr
 			}
-			newestRpt, rErr := analyzeProfileText(newestText, opts)
+			newestRpt, rErr := analysis.Run(newestProfiles, opts)
 			if rErr != nil {
 				return rErr
 			}
 			newestRpt.GeneratedAt = time.Now().UTC()
 
 			reports := make([]*report.Report, 0, len(groups))
-			// Older builds use covdata func (no AST dependency).
+			// Older builds use per-function stats only (no AST dependency).
 			for _, g := range groups[:len(groups)-1] {
-				funcCov, fErr := covparse.RunCovdataFunc(g.Dirs)
+				funcCov, fErr := g.FuncCoverage()
 				if fErr != nil {
 					return fErr
 				}
@@ -96,16 +104,26 @@ func runAnalyze(args []string) error {
 		}
 		rpt, err = analyzeProfileText(profileText, opts)
 	default:
-		profileText, parseErr := covparse.ParseDir(*coverDir)
+		dirs, selErr := covparse.SelectDirs([]string{*coverDir}, sel)
+		if selErr != nil {
+			return selErr
+		}
+		if len(dirs) == 0 {
+			return fmt.Errorf("coverage data in %s does not match labels %s", *coverDir, sel)
+		}
+		profiles, parseErr := covparse.BuildGroup{Dirs: dirs, Labels: sel}.Profiles()
 		if parseErr != nil {
 			return parseErr
 		}
-		rpt, err = analyzeProfileText(profileText, opts)
+		rpt, err = analysis.Run(profiles, opts)
 	}
 	if err != nil {
 		return err
 	}
 	rpt.GeneratedAt = time.Now().UTC()
+	if len(sel) > 0 {
+		rpt.Labels = sel
+	}
 
 	w := os.Stdout
 	if *outputFile != "" {
@@ -144,8 +162,8 @@ func analyzeProfileText(text string, opts analysis.Options) (*report.Report, err
 	return analysis.Run(profiles, opts)
 }
 
-// reportFromFuncCoverage builds a minimal Report from covdata func output.
-// TotalStatements/CoveredStatements are set to 0 since covdata func only
+// reportFromFuncCoverage builds a minimal Report from per-function coverage.
+// TotalStatements/CoveredStatements are set to 0 since FuncCoverage only
 // provides a coverage percentage. The merge step will reconcile these
 // using the base (newest build) report's statement counts.
 func reportFromFuncCoverage(funcs []covparse.FuncCoverage, opts analysis.Options) *report.Report {
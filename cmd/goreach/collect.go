@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yag13s/goreach/flush/collect"
+)
+
+func runCollect(args []string) error {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	dir := fs.String("dir", "", "directory to store flushes in (required)")
+	tokenFile := fs.String("token-file", "", "file with accepted bearer tokens, one per line (default: $GOREACH_COLLECT_TOKENS, comma-separated)")
+	maxBytes := fs.Int64("max-bytes", 64<<20, "maximum size of one flush request in bytes")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file (enables HTTPS)")
+	tlsKey := fs.String("tls-key", "", "TLS key file")
+	timeout := fs.Duration("timeout", 2*time.Minute, "maximum time to read a flush request and write the response")
+	allowUnauth := fs.Bool("allow-unauthenticated", false, "accept flushes without a token if no tokens are configured")
+	_ = fs.Parse(args) // ExitOnError: never returns error
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	tokens, err := readTokens(*tokenFile, os.Getenv("GOREACH_COLLECT_TOKENS"))
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 && !*allowUnauth {
+		return fmt.Errorf("no tokens configured: set -token-file or $GOREACH_COLLECT_TOKENS, or pass -allow-unauthenticated")
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if len(tokens) == 0 {
+		logger.Warn("goreach collect: no tokens configured, accepting unauthenticated flushes")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /flush", &collect.Server{
+		Dir:                  *dir,
+		Tokens:               tokens,
+		AllowUnauthenticated: *allowUnauth,
+		MaxBytes:             *maxBytes,
+		Logger:               logger,
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	srv := newCollectServer(*addr, mux, *timeout)
+	logger.Info("goreach collect: listening", "addr", *addr, "dir", *dir)
+	if *tlsCert != "" {
+		return srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	}
+	return srv.ListenAndServe()
+}
+
+// newCollectServer returns the server for the ingestion port. Its timeouts
+// keep slow or idle clients from holding connections open indefinitely,
+// which matters most when the port accepts unauthenticated flushes.
+func newCollectServer(addr string, h http.Handler, timeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       timeout,
+		WriteTimeout:      timeout + 10*time.Second, // counted from the end of the headers
+		IdleTimeout:       time.Minute,
+	}
+}
+
+// readTokens returns the tokens in file, one per line, or else the
+// comma-separated tokens in env. Blank lines and lines starting with '#'
+// are ignored.
+func readTokens(file, env string) ([]string, error) {
+	var lines []string
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("read tokens: %w", err)
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("read tokens: %w", err)
+		}
+	} else {
+		lines = strings.Split(env, ",")
+	}
+
+	var tokens []string
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, "#") {
+			tokens = append(tokens, l)
+		}
+	}
+	return tokens, nil
+}
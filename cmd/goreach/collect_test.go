@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadTokens(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(file, []byte("# ci\nabc\n\n  def  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readTokens(file, "ignored")
+	if err != nil || !slices.Equal(got, []string{"abc", "def"}) {
+		t.Errorf("readTokens(file) = %q, %v", got, err)
+	}
+	got, err = readTokens("", "abc, def,")
+	if err != nil || !slices.Equal(got, []string{"abc", "def"}) {
+		t.Errorf("readTokens(env) = %q, %v", got, err)
+	}
+	got, err = readTokens("", "")
+	if err != nil || len(got) != 0 {
+		t.Errorf("readTokens(empty) = %q, %v", got, err)
+	}
+}
+
+func TestNewCollectServer_Timeouts(t *testing.T) {
+	srv := newCollectServer(":0", nil, time.Minute)
+	if srv.ReadHeaderTimeout <= 0 || srv.IdleTimeout <= 0 {
+		t.Errorf("ReadHeaderTimeout = %v, IdleTimeout = %v; want both set", srv.ReadHeaderTimeout, srv.IdleTimeout)
+	}
+	if srv.ReadTimeout != time.Minute || srv.WriteTimeout < srv.ReadTimeout {
+		t.Errorf("ReadTimeout = %v, WriteTimeout = %v", srv.ReadTimeout, srv.WriteTimeout)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 5*time.Minute, "time to wait for the command to complete")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goreach ctl [-timeout d] <socket> flush|clear|status")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args) // ExitOnError: never returns error
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("socket and command are required")
+	}
+	socket, cmd := fs.Arg(0), fs.Arg(1)
+	switch cmd {
+	case flush.ControlFlush, flush.ControlClear, flush.ControlStatus:
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	line, err := ctl(socket, cmd, *timeout)
+	if err != nil {
+		return err
+	}
+	return printCtlResponse(os.Stdout, line)
+}
+
+// ctl sends cmd to the control socket of a Flusher and returns the JSON
+// response line.
+func ctl(socket, cmd string, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(conn, cmd+"\n"); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	return line, nil
+}
+
+// printCtlResponse prints the status of a status response, indented, and
+// returns the error of a failed command.
+func printCtlResponse(w io.Writer, line []byte) error {
+	var resp struct {
+		OK     bool            `json:"ok"`
+		Error  string          `json:"error"`
+		Status json.RawMessage `json:"status"`
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	if len(resp.Status) == 0 {
+		_, err := fmt.Fprintln(w, "ok")
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, resp.Status, "", "  "); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCtl(t *testing.T) {
+	dir, err := os.MkdirTemp("", "goreach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ctl.sock")
+
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if cmd, _ := bufio.NewReader(conn).ReadString('\n'); cmd == "status\n" {
+			conn.Write([]byte(`{"ok":true,"status":{"enabled":true}}` + "\n"))
+		}
+	}()
+
+	line, err := ctl(sock, "status", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"ok":true,"status":{"enabled":true}}` + "\n"; string(line) != want {
+		t.Errorf("ctl() = %q, want %q", line, want)
+	}
+}
+
+func TestPrintCtlResponse(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printCtlResponse(&buf, []byte(`{"ok":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ok\n" {
+		t.Errorf("output = %q, want ok", buf.String())
+	}
+
+	buf.Reset()
+	if err := printCtlResponse(&buf, []byte(`{"ok":true,"status":{"enabled":true}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"enabled\": true\n}\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	err := printCtlResponse(&buf, []byte(`{"ok":false,"error":"bucket unavailable"}`))
+	if err == nil || err.Error() != "bucket unavailable" {
+		t.Errorf("err = %v, want bucket unavailable", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yag13s/goreach/flush/objstore"
+)
+
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "bucket URL, e.g. https://bucket.s3.us-east-1.amazonaws.com (required)")
+	region := fs.String("region", os.Getenv("AWS_REGION"), "region for request signing (default $AWS_REGION)")
+	prefix := fs.String("prefix", "goreach", "key prefix used by the flusher")
+	service := fs.String("service", "", "only fetch this service")
+	buildVersion := fs.String("version", "", "only fetch this build version")
+	since := fs.String("since", "", "only fetch objects modified after this time (RFC 3339 or a duration such as 24h)")
+	until := fs.String("until", "", "only fetch objects modified before this time (RFC 3339 or a duration)")
+	outputDir := fs.String("o", "", "local directory to mirror into (required)")
+	_ = fs.Parse(args) // ExitOnError: never returns error
+
+	if *endpoint == "" || *outputDir == "" {
+		return fmt.Errorf("-endpoint and -o are required")
+	}
+
+	now := time.Now()
+	sinceTime, err := parseTimeFlag(*since, now)
+	if err != nil {
+		return fmt.Errorf("-since: %w", err)
+	}
+	untilTime, err := parseTimeFlag(*until, now)
+	if err != nil {
+		return fmt.Errorf("-until: %w", err)
+	}
+
+	d := &objstore.HTTPDownloader{Endpoint: *endpoint}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		d.Signer = &objstore.SigV4{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Region:          *region,
+		}
+		if d.Signer.Region == "" {
+			return fmt.Errorf("-region or AWS_REGION is required for signed requests")
+		}
+	}
+
+	f := &objstore.Fetcher{
+		List:     d.List,
+		Download: d.Download,
+		Prefix:   *prefix,
+		Service:  *service,
+		Version:  *buildVersion,
+		Since:    sinceTime,
+		Until:    untilTime,
+	}
+	stats, err := f.Fetch(context.Background(), *outputDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "fetched %d objects (%d bytes), %d up to date\n", stats.Downloaded, stats.Bytes, stats.UpToDate)
+	return nil
+}
+
+// parseTimeFlag parses an RFC 3339 time or a duration before now. Empty
+// yields the zero time.
+func parseTimeFlag(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want RFC 3339 time or duration, got %q", s)
+	}
+	return t, nil
+}
@@ -41,6 +41,26 @@ func main() {
 			fmt.Fprintf(os.Stderr, "goreach merge: %v\n", err)
 			os.Exit(1)
 		}
+	case "collect":
+		if err := runCollect(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "goreach collect: %v\n", err)
+			os.Exit(1)
+		}
+	case "ctl":
+		if err := runCtl(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "goreach ctl: %v\n", err)
+			os.Exit(1)
+		}
+	case "fetch":
+		if err := runFetch(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "goreach fetch: %v\n", err)
+			os.Exit(1)
+		}
+	case "scrape":
+		if err := runScrape(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "goreach scrape: %v\n", err)
+			os.Exit(1)
+		}
 	case "view":
 		if err := runView(os.Args[2:]); err != nil {
 			fmt.Fprintf(os.Stderr, "goreach view: %v\n", err)
@@ -58,7 +78,11 @@ func usage() {
 
 Commands:
   analyze   Analyze coverage data and output JSON report
+  collect   Receive flushes over HTTP and store them in a directory
+  ctl       Send flush, clear or status to a process's control socket
+  fetch     Mirror coverage data from an object store into a local directory
   merge     Merge multiple report.json files (max coverage per function)
+  scrape    Pull coverage from flushhttp endpoints into a directory
   summary   Print coverage summary as text
   view      Open report.json in browser UI
   version   Print version information`)
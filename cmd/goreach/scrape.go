@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yag13s/goreach/flush/collect"
+)
+
+func runScrape(args []string) error {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	targetsFile := fs.String("targets", "", "file listing targets (host:port or URL), one per line; re-read every round")
+	srv := fs.String("srv", "", "DNS SRV name to resolve targets from, e.g. _http._tcp.api.default.svc.cluster.local")
+	scheme := fs.String("scheme", "http", "scheme for targets without one")
+	path := fs.String("path", "/internal/coverage", "path of the flushhttp endpoints")
+	dir := fs.String("dir", "", "directory to store coverage in (required)")
+	interval := fs.Duration("interval", time.Minute, "scrape interval")
+	once := fs.Bool("once", false, "scrape once and exit")
+	concurrency := fs.Int("concurrency", 8, "targets scraped in parallel")
+	_ = fs.Parse(args) // ExitOnError: never returns error
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if (*targetsFile == "") == (*srv == "") {
+		return fmt.Errorf("exactly one of -targets and -srv is required")
+	}
+
+	s := &collect.Scraper{
+		Dir:         *dir,
+		Path:        *path,
+		Token:       os.Getenv("GOREACH_SCRAPE_TOKEN"),
+		Concurrency: *concurrency,
+		Logger:      slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}
+	if *targetsFile != "" {
+		s.Targets = func(context.Context) ([]string, error) { return fileTargets(*targetsFile, *scheme) }
+	} else {
+		s.Targets = func(ctx context.Context) ([]string, error) { return srvTargets(ctx, *srv, *scheme) }
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *once {
+		return s.Scrape(ctx)
+	}
+	if err := s.Run(ctx, *interval); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// fileTargets reads targets from file, one per line. Blank lines and lines
+// starting with '#' are ignored.
+func fileTargets(file, scheme string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var targets []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "://") {
+			line = scheme + "://" + line
+		}
+		targets = append(targets, line)
+	}
+	return targets, sc.Err()
+}
+
+// srvTargets resolves the SRV record name into target URLs.
+func srvTargets(ctx context.Context, name, scheme string) ([]string, error) {
+	_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		host := strings.TrimSuffix(a.Target, ".")
+		targets = append(targets, scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(a.Port))))
+	}
+	return targets, nil
+}
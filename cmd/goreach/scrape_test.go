@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFileTargets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "targets")
+	if err := os.WriteFile(file, []byte("# api pods\n10.0.0.1:8080\n\nhttps://api-1:8443\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileTargets(file, "http")
+	want := []string{"http://10.0.0.1:8080", "https://api-1:8443"}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("fileTargets = %q, %v; want %q", got, err, want)
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 
@@ -24,44 +23,28 @@ func runSummary(args []string) error {
 		return fmt.Errorf("either -profile or -coverdir is required")
 	}
 
-	var profileText string
+	var profiles []*cover.Profile
 	var err error
 	switch {
 	case *profilePath != "":
-		profileText, err = covparse.ParseProfileFile(*profilePath)
+		profiles, err = cover.ParseProfiles(*profilePath)
+		if err != nil {
+			return fmt.Errorf("parse profiles: %w", err)
+		}
 	case *recursive:
 		// Use only the newest build group's profile for summary.
 		var groups []covparse.BuildGroup
-		groups, err = covparse.ParseDirRecursiveGrouped(*coverDir)
+		groups, err = covparse.ParseDirRecursiveGrouped(*coverDir, nil)
 		if err == nil && len(groups) > 0 {
-			profileText, err = groups[len(groups)-1].ParseProfile()
+			profiles, err = groups[len(groups)-1].Profiles()
 		}
 	default:
-		profileText, err = covparse.ParseDir(*coverDir)
+		profiles, err = covparse.ReadProfiles([]string{*coverDir})
 	}
 	if err != nil {
 		return err
 	}
 
-	tmpFile, err := os.CreateTemp("", "goreach-summary-*.txt")
-	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	if _, err := tmpFile.WriteString(profileText); err != nil {
-		_ = tmpFile.Close()
-		return fmt.Errorf("write temp file: %w", err)
-	}
-	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("close temp file: %w", err)
-	}
-
-	profiles, err := cover.ParseProfiles(tmpFile.Name())
-	if err != nil {
-		return fmt.Errorf("parse profiles: %w", err)
-	}
-
 	// Compute summary per package
 	type pkgStats struct {
 		total, covered int
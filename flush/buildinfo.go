@@ -0,0 +1,85 @@
+package flush
+
+import (
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// readBuildInfo is replaceable in tests, whose binaries carry no VCS stamp.
+var readBuildInfo = debug.ReadBuildInfo
+
+// withBuildInfo fills in ServiceName, BuildVersion and BuildTime from the
+// build information embedded by the Go toolchain when they are not set:
+//
+//   - ServiceName: last element of the main package path
+//   - BuildVersion: vcs.revision (12 characters, "-dirty" if vcs.modified),
+//     or the main module version when built with `go install pkg@version`
+//   - BuildTime: vcs.time
+func (cfg Config) withBuildInfo() Config {
+	bi, ok := readBuildInfo()
+	if !ok {
+		return cfg
+	}
+
+	if cfg.ServiceName == "" {
+		p := bi.Path
+		if p == "" {
+			p = bi.Main.Path
+		}
+		if p != "" && p != "command-line-arguments" {
+			cfg.ServiceName = path.Base(p)
+		}
+	}
+
+	var revision, vcsTime string
+	var modified bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			vcsTime = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+
+	if cfg.BuildVersion == "" {
+		switch {
+		case revision != "":
+			cfg.BuildVersion = revision[:min(len(revision), 12)]
+			if modified {
+				cfg.BuildVersion += "-dirty"
+			}
+		case bi.Main.Version != "" && bi.Main.Version != "(devel)":
+			cfg.BuildVersion = bi.Main.Version
+		}
+	}
+	if cfg.BuildTime.IsZero() && vcsTime != "" {
+		if t, err := time.Parse(time.RFC3339, vcsTime); err == nil {
+			cfg.BuildTime = t
+		}
+	}
+	return cfg
+}
+
+// metaHashVersion derives a build identity from the covmeta files in files.
+// The covmeta hash covers the binary's coverage meta-data, so it is stable
+// across restarts and pods of the same build and changes with the code.
+func metaHashVersion(files []string) string {
+	var hashes []string
+	for _, f := range files {
+		if hash, ok := strings.CutPrefix(filepath.Base(f), "covmeta."); ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return ""
+	}
+	sort.Strings(hashes)
+	return "covmeta-" + hashes[0][:min(len(hashes[0]), 12)]
+}
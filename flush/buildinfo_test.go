@@ -0,0 +1,91 @@
+package flush
+
+import (
+	"context"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func fakeBuildInfo(t *testing.T, bi *debug.BuildInfo) {
+	t.Helper()
+	orig := readBuildInfo
+	t.Cleanup(func() { readBuildInfo = orig })
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return bi, bi != nil }
+}
+
+func TestConfig_WithBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Path: "example.com/shop/cmd/checkout",
+		Main: debug.Module{Path: "example.com/shop", Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+			{Key: "vcs.time", Value: "2026-03-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		bi          *debug.BuildInfo
+		cfg         Config
+		wantService string
+		wantVersion string
+		wantTime    time.Time
+	}{
+		{"vcs", bi, Config{}, "checkout", "0123456789ab-dirty", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"explicit wins", bi, Config{ServiceName: "svc", BuildVersion: "v1"}, "svc", "v1", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"module version", &debug.BuildInfo{Path: "example.com/tool", Main: debug.Module{Path: "example.com/tool", Version: "v1.4.0"}}, Config{}, "tool", "v1.4.0", time.Time{}},
+		{"no build info", nil, Config{}, "", "", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeBuildInfo(t, tt.bi)
+			got := tt.cfg.withBuildInfo()
+			if got.ServiceName != tt.wantService || got.BuildVersion != tt.wantVersion || !got.BuildTime.Equal(tt.wantTime) {
+				t.Errorf("got service=%q version=%q time=%v, want %q %q %v",
+					got.ServiceName, got.BuildVersion, got.BuildTime, tt.wantService, tt.wantVersion, tt.wantTime)
+			}
+		})
+	}
+}
+
+// TestFlush_MetaHashVersion tests that flushes without any build version
+// fall back to an identity derived from the covmeta hash.
+func TestFlush_MetaHashVersion(t *testing.T) {
+	fakeCoverage(t)
+	fakeBuildInfo(t, nil)
+
+	storage := &recordStorage{}
+	f := New(Config{Storage: storage})
+	defer f.Stop()
+	if err := f.Emit(); err != nil {
+		t.Fatal(err)
+	}
+	if storage.meta.BuildVersion != "covmeta-abc000000000" {
+		t.Errorf("BuildVersion = %q, want covmeta-abc000000000", storage.meta.BuildVersion)
+	}
+}
+
+// recordStorage records the Metadata of the last Store call.
+type recordStorage struct{ meta Metadata }
+
+func (s *recordStorage) Store(_ context.Context, _ []string, meta Metadata) error {
+	s.meta = meta
+	return nil
+}
+
+func TestConfig_Metadata(t *testing.T) {
+	fakeCoverage(t)
+	fakeBuildInfo(t, nil)
+
+	meta := Config{ServiceName: "svc", Labels: map[string]string{"env": "stg"}}.Metadata()
+	if meta.ServiceName != "svc" || meta.Labels["env"] != "stg" {
+		t.Errorf("Metadata = %+v", meta)
+	}
+	// Without build info, the version falls back to the meta-data hash, as
+	// for pushed flushes.
+	if meta.BuildVersion != "covmeta-abc000000000" {
+		t.Errorf("BuildVersion = %q, want covmeta-abc000000000", meta.BuildVersion)
+	}
+}
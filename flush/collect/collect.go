@@ -0,0 +1,358 @@
+// Package collect sends flushes over HTTP to a central collector, for
+// environments where workloads have no object store credentials.
+//
+// [Client] is a [flush.Storage] that posts each flush to a [Server], which
+// stores it in the directory layout goreach analyze -r reads. The goreach
+// collect command runs a Server. [Scraper] fills the same layout by pulling
+// from processes serving flushhttp.Handler instead; goreach scrape runs it.
+package collect
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// metadataField is the multipart form field carrying the flush Metadata as
+// JSON. It precedes the file parts.
+const metadataField = "metadata"
+
+// Client is a [flush.Storage] that posts each flush to a collector [Server]
+// as one multipart/form-data request: a "metadata" part followed by one
+// "file" part per coverage file.
+type Client struct {
+	URL        string       // collector endpoint, e.g. http://collector:8080/flush (required)
+	Token      string       // sent as "Authorization: Bearer <Token>" if set
+	HTTPClient *http.Client // default http.DefaultClient
+}
+
+// compile-time check
+var (
+	_ flush.StreamStorage = (*Client)(nil)
+	_ flush.Validator     = (*Client)(nil)
+)
+
+// Validate checks that URL is an absolute http(s) URL.
+func (c *Client) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("goreach/flush: collect: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("goreach/flush: collect: URL %q is not an http(s) URL", c.URL)
+	}
+	return nil
+}
+
+// Store posts files to the collector.
+func (c *Client) Store(ctx context.Context, files []string, meta flush.Metadata) error {
+	streams := make([]flush.StreamFile, 0, len(files))
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("goreach/flush: open %s: %w", filepath.Base(p), err)
+		}
+		defer f.Close()
+		streams = append(streams, flush.StreamFile{Name: filepath.Base(p), Body: f})
+	}
+	return c.StoreStreams(ctx, streams, meta)
+}
+
+// StoreStreams posts files to the collector without buffering them.
+func (c *Client) StoreStreams(ctx context.Context, files []flush.StreamFile, meta flush.Metadata) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("goreach/flush: collect: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeForm(mw, metaJSON, files))
+	}()
+	defer pr.Close() // unblocks the writer if the request fails early
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, pr)
+	if err != nil {
+		return fmt.Errorf("goreach/flush: collect: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("goreach/flush: collect: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("goreach/flush: collect: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func writeForm(mw *multipart.Writer, metaJSON []byte, files []flush.StreamFile) error {
+	if err := mw.WriteField(metadataField, string(metaJSON)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		part, err := mw.CreateFormFile("file", f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, f.Body); err != nil {
+			return fmt.Errorf("read %s: %w", f.Name, err)
+		}
+	}
+	return mw.Close()
+}
+
+// Server is an http.Handler that receives flushes posted by [Client] and
+// stores them under Dir as
+//
+//	<Dir>/<service>/<version>[/<label>=<value>...]/<pod>/<file>
+//
+// which is the default objstore key layout without the prefix. Each flush
+// is staged and moved into place only once it was received completely.
+type Server struct {
+	Dir string // root directory (required)
+
+	// Tokens lists the accepted bearer tokens. If it is empty, every
+	// request is rejected unless AllowUnauthenticated is set.
+	Tokens []string
+
+	// AllowUnauthenticated accepts requests without a token if Tokens is
+	// empty, e.g. behind an authenticating proxy.
+	AllowUnauthenticated bool
+
+	// MaxBytes limits the size of a request body (default 64 MiB).
+	MaxBytes int64
+
+	Logger *slog.Logger // nil disables logging
+}
+
+// ServeHTTP handles a POST with a flush.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	maxBytes := s.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	if r.ContentLength > maxBytes {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	dir, n, err := s.receive(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+			status = http.StatusRequestEntityTooLarge
+		} else if errors.Is(err, errStore) {
+			status = http.StatusInternalServerError
+		}
+		s.log(slog.LevelWarn, "goreach collect: rejected flush", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.log(slog.LevelInfo, "goreach collect: stored flush", "remote", r.RemoteAddr, "dir", dir, "files", n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "files": n})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.Tokens) == 0 {
+		return s.AllowUnauthenticated
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, t := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// errStore marks failures on the server side.
+var errStore = errors.New("store failed")
+
+// receive reads the multipart flush in r into a staging directory and then
+// moves the files into place. It returns the flush directory and the number
+// of files.
+func (s *Server) receive(r *http.Request) (string, int, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", 0, errors.New("want multipart/form-data")
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != metadataField {
+		return "", 0, fmt.Errorf("missing %s part", metadataField)
+	}
+	var meta flush.Metadata
+	if err := json.NewDecoder(part).Decode(&meta); err != nil {
+		return "", 0, fmt.Errorf("decode metadata: %w", err)
+	}
+	dir, err := flushDir(s.Dir, meta)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Files are staged as hidden temporary files in the flush directory,
+	// which readers ignore, and renamed once the request is complete.
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, fmt.Errorf("%w: %w", errStore, err)
+	}
+	staged := make(map[string]string) // file name -> temporary path
+	defer func() {
+		for _, tmp := range staged {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		name := part.FileName()
+		if part.FormName() != "file" || !validFileName(name) {
+			return "", 0, fmt.Errorf("unexpected part %q (file %q)", part.FormName(), name)
+		}
+		if _, ok := staged[name]; ok {
+			return "", 0, fmt.Errorf("duplicate file %q", name)
+		}
+		tmp, err := stageFile(dir, name, part)
+		if tmp != "" {
+			staged[name] = tmp
+		}
+		if err != nil {
+			if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+				return "", 0, err
+			}
+			return "", 0, fmt.Errorf("%w: %w", errStore, err)
+		}
+	}
+	if len(staged) == 0 {
+		return "", 0, errors.New("no files")
+	}
+
+	// Coverage files first, so that the manifest never describes a flush
+	// that is not there yet.
+	names := slices.SortedFunc(maps.Keys(staged), func(a, b string) int {
+		return compareBool(flush.IsManifest(a), flush.IsManifest(b))
+	})
+	for _, name := range names {
+		if err := os.Rename(staged[name], filepath.Join(dir, name)); err != nil {
+			return "", 0, fmt.Errorf("%w: %w", errStore, err)
+		}
+		delete(staged, name)
+	}
+	return dir, len(names), nil
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// validFileName reports whether name is a file a flush may contain.
+func validFileName(name string) bool {
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	return strings.HasPrefix(name, "covmeta.") || strings.HasPrefix(name, "covcounters.") || flush.IsManifest(name)
+}
+
+// flushDir returns the directory for a flush with meta under root.
+func flushDir(root string, meta flush.Metadata) (string, error) {
+	segs := []string{segment(meta.ServiceName), segment(meta.BuildVersion)}
+	for _, k := range slices.Sorted(maps.Keys(meta.Labels)) {
+		segs = append(segs, url.PathEscape(k)+"="+url.PathEscape(meta.Labels[k]))
+	}
+	segs = append(segs, segment(meta.PodName))
+	rel := filepath.Join(segs...)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid metadata path %q", rel)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// segment makes s safe as a single path element.
+func segment(s string) string {
+	s = url.PathEscape(s)
+	if s == "" || s == "." || s == ".." {
+		return "unknown"
+	}
+	return s
+}
+
+// stageFile writes r to a hidden temporary file for name in dir and
+// returns its path, which is set even on failure once the file exists.
+func stageFile(dir, name string, r io.Reader) (string, error) {
+	out, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if err := out.Chmod(0o644); err != nil {
+		return out.Name(), err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		return out.Name(), err
+	}
+	return out.Name(), out.Close()
+}
+
+func (s *Server) log(level slog.Level, msg string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Log(context.Background(), level, msg, args...)
+	}
+}
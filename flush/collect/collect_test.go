@@ -0,0 +1,153 @@
+package collect
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+func testFiles() []flush.StreamFile {
+	return []flush.StreamFile{
+		{Name: "covmeta.abc", Body: strings.NewReader("meta")},
+		{Name: "covcounters.abc.1.2", Body: strings.NewReader("counters")},
+		{Name: flush.ManifestName("covcounters.abc.1.2"), Body: strings.NewReader("{}")},
+	}
+}
+
+var testMeta = flush.Metadata{
+	Timestamp:    time.Unix(1, 0),
+	ServiceName:  "api",
+	BuildVersion: "v1",
+	PodName:      "pod-0",
+	Labels:       map[string]string{"env": "stg"},
+}
+
+func TestClientServer(t *testing.T) {
+	dir := t.TempDir()
+	srv := httptest.NewServer(&Server{Dir: dir, Tokens: []string{"other", "secret"}})
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, Token: "secret"}
+	if err := c.StoreStreams(context.Background(), testFiles(), testMeta); err != nil {
+		t.Fatal(err)
+	}
+
+	flushDir := filepath.Join(dir, "api", "v1", "env=stg", "pod-0")
+	for name, want := range map[string]string{"covmeta.abc": "meta", "covcounters.abc.1.2": "counters", flush.ManifestName("covcounters.abc.1.2"): "{}"} {
+		data, err := os.ReadFile(filepath.Join(flushDir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+	entries, _ := os.ReadDir(flushDir)
+	if len(entries) != 3 {
+		t.Errorf("flush dir has %d entries, want 3 (no leftovers)", len(entries))
+	}
+}
+
+func TestClient_Store(t *testing.T) {
+	src := t.TempDir()
+	file := filepath.Join(src, "covcounters.abc.1.2")
+	if err := os.WriteFile(file, []byte("counters"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	srv := httptest.NewServer(&Server{Dir: dir, AllowUnauthenticated: true})
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL}
+	if err := c.Store(context.Background(), []string{file}, flush.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unknown", "unknown", "unknown", "covcounters.abc.1.2")); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestServer_Rejects tests that rejected flushes leave nothing behind.
+func TestServer_Rejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		meta  flush.Metadata
+		files []flush.StreamFile
+		want  string
+	}{
+		{"no token", "", testMeta, testFiles(), "401"},
+		{"wrong token", "guess", testMeta, testFiles(), "401"},
+		{"too large", "secret", testMeta, []flush.StreamFile{
+			{Name: "covcounters.abc.1.2", Body: strings.NewReader(strings.Repeat("x", 2048))},
+		}, "413"},
+		{"bad file name", "secret", testMeta, []flush.StreamFile{
+			{Name: "covmeta.abc", Body: strings.NewReader("meta")},
+			{Name: "../covmeta.abc", Body: strings.NewReader("meta")},
+		}, "400"},
+		{"path traversal", "secret", flush.Metadata{ServiceName: "..", PodName: "../../etc"}, testFiles(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			srv := httptest.NewServer(&Server{Dir: dir, Tokens: []string{"secret"}, MaxBytes: 1024})
+			defer srv.Close()
+
+			c := &Client{URL: srv.URL, Token: tt.token}
+			err := c.StoreStreams(context.Background(), tt.files, tt.meta)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Stored, but inside dir.
+				if _, err := os.Stat(filepath.Join(dir, "unknown", "unknown", "..%2F..%2Fetc", "covmeta.abc")); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %s", err, tt.want)
+			}
+			var files []string
+			filepath.WalkDir(dir, func(path string, d os.DirEntry, _ error) error {
+				if !d.IsDir() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if len(files) != 0 {
+				t.Errorf("rejected flush left %v", files)
+			}
+		})
+	}
+}
+
+// TestServer_NoTokens tests that a Server without tokens rejects every
+// request unless AllowUnauthenticated is set.
+func TestServer_NoTokens(t *testing.T) {
+	dir := t.TempDir()
+	srv := httptest.NewServer(&Server{Dir: dir})
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, Token: "secret"}
+	if err := c.StoreStreams(context.Background(), testFiles(), testMeta); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err = %v, want 401", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("rejected flush left %d entries", len(entries))
+	}
+}
+
+func TestClient_Validate(t *testing.T) {
+	for _, u := range []string{"", "collector:8080", "ftp://collector"} {
+		if err := (&Client{URL: u}).Validate(); err == nil {
+			t.Errorf("Validate(%q) = nil, want error", u)
+		}
+	}
+	if err := (&Client{URL: "http://collector:8080/flush"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
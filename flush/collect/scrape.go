@@ -0,0 +1,265 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// Scraper pulls coverage from processes serving flushhttp.Handler and
+// stores it under Dir in the same layout as [Server].
+//
+// Counters read from a process are cumulative, so for each process only the
+// newest covcounters file is kept. Do not combine scraping with flushers
+// that clear counters.
+type Scraper struct {
+	Dir string // root directory (required)
+
+	// Targets returns the base URLs of the processes to scrape, e.g.
+	// http://10.0.3.7:8080. It is called once per Scrape.
+	Targets func(ctx context.Context) ([]string, error)
+
+	Path        string       // path of the flushhttp endpoints (default "/internal/coverage")
+	Token       string       // sent as "Authorization: Bearer <Token>" if set
+	Client      *http.Client // default http.DefaultClient
+	Concurrency int          // targets scraped in parallel (default 8)
+
+	Logger *slog.Logger // nil disables logging
+
+	mu    sync.Mutex
+	etags map[string]string // target -> ETag of the stored covmeta
+}
+
+// Run scrapes all targets every interval until ctx is done. Failures are
+// logged and do not stop Run.
+func (s *Scraper) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("goreach/flush: scrape: interval must be positive")
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := s.Scrape(ctx); err != nil {
+			s.log(slog.LevelWarn, "goreach scrape: round failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Scrape scrapes all targets once and returns the joined errors of the
+// targets that failed.
+func (s *Scraper) Scrape(ctx context.Context) error {
+	if s.Dir == "" || s.Targets == nil {
+		return errors.New("goreach/flush: scrape: Dir and Targets are required")
+	}
+	targets, err := s.Targets(ctx)
+	if err != nil {
+		return fmt.Errorf("goreach/flush: scrape: targets: %w", err)
+	}
+
+	errs := make([]error, len(targets))
+	n := s.Concurrency
+	if n <= 0 {
+		n = 8
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			dir, err := s.scrapeTarget(ctx, strings.TrimSuffix(target, "/"))
+			if err != nil {
+				errs[i] = fmt.Errorf("scrape %s: %w", target, err)
+				return
+			}
+			s.log(slog.LevelInfo, "goreach scrape: stored", "target", target, "dir", dir)
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// scrapeTarget stores meta-data, counters and manifest of one target and
+// returns the directory they were stored in.
+func (s *Scraper) scrapeTarget(ctx context.Context, target string) (string, error) {
+	base := target + s.path()
+
+	body, _, err := s.get(ctx, base+"/manifest", "")
+	if err != nil {
+		return "", err
+	}
+	var meta flush.Metadata
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return "", fmt.Errorf("decode manifest: %w", err)
+	}
+	dir, err := flushDir(s.Dir, meta)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	if err := s.storeMeta(ctx, target, base, dir); err != nil {
+		return "", err
+	}
+
+	counters, name, err := s.get(ctx, base+"/counters", "")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(name, "covcounters.") || !validFileName(name) {
+		return "", fmt.Errorf("unexpected counters file name %q", name)
+	}
+	if err := storeFile(dir, name, counters); err != nil {
+		return "", err
+	}
+	removeOlderCounters(dir, name)
+
+	manifest, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	return dir, storeFile(dir, flush.ManifestName(name), append(manifest, '\n'))
+}
+
+// storeMeta stores the covmeta file of target in dir unless the stored copy
+// is still current.
+func (s *Scraper) storeMeta(ctx context.Context, target, base, dir string) error {
+	s.mu.Lock()
+	etag := s.etags[target]
+	s.mu.Unlock()
+
+	data, name, err := s.get(ctx, base+"/meta", etag)
+	if errors.Is(err, errNotModified) {
+		hash := strings.Trim(etag, `"`)
+		if _, err := os.Stat(filepath.Join(dir, "covmeta."+hash)); err == nil {
+			return nil
+		}
+		// Stored elsewhere, e.g. the target's manifest changed.
+		data, name, err = s.get(ctx, base+"/meta", "")
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(name, "covmeta.") || !validFileName(name) {
+		return fmt.Errorf("unexpected meta file name %q", name)
+	}
+	if err := storeFile(dir, name, data); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.etags == nil {
+		s.etags = make(map[string]string)
+	}
+	s.etags[target] = `"` + strings.TrimPrefix(name, "covmeta.") + `"`
+	return nil
+}
+
+// errNotModified is returned by get for a 304 response.
+var errNotModified = errors.New("not modified")
+
+// get fetches url and returns the body and the file name from its
+// Content-Disposition header, if any.
+func (s *Scraper) get(ctx context.Context, url, ifNoneMatch string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, "", fmt.Errorf("GET %s: %s: %s", url, resp.Status, bytes.TrimSpace(msg))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var name string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	return data, name, nil
+}
+
+func (s *Scraper) path() string {
+	if s.Path == "" {
+		return "/internal/coverage"
+	}
+	return "/" + strings.Trim(s.Path, "/")
+}
+
+// storeFile writes data to dir/name via a hidden temporary file.
+func storeFile(dir, name string, data []byte) error {
+	tmp, err := stageFile(dir, name, bytes.NewReader(data))
+	if err != nil {
+		if tmp != "" {
+			_ = os.Remove(tmp)
+		}
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeOlderCounters removes the covcounters files in dir written by the
+// same process as name (covcounters.<hash>.<pid>.<nanos>) before it.
+func removeOlderCounters(dir, name string) {
+	processPrefix := name[:strings.LastIndexByte(name, '.')+1]
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if n := e.Name(); n != name && strings.HasPrefix(n, processPrefix) {
+			_ = os.Remove(filepath.Join(dir, n))
+			_ = os.Remove(filepath.Join(dir, flush.ManifestName(n)))
+		}
+	}
+}
+
+func (s *Scraper) log(level slog.Level, msg string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Log(context.Background(), level, msg, args...)
+	}
+}
@@ -0,0 +1,102 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// fakeTarget serves the flushhttp endpoints used by Scraper and counts the
+// full meta-data responses.
+func fakeTarget(t *testing.T, pod string, metaServed *atomic.Int32) *httptest.Server {
+	t.Helper()
+	var seq atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /internal/coverage/manifest", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(flush.Metadata{ServiceName: "api", BuildVersion: "v1", PodName: pod})
+	})
+	mux.HandleFunc("GET /internal/coverage/meta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="covmeta.abc"`)
+		w.Header().Set("ETag", `"abc"`)
+		if r.Header.Get("If-None-Match") != `"abc"` {
+			metaServed.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("meta")))
+	})
+	mux.HandleFunc("GET /internal/coverage/counters", func(w http.ResponseWriter, _ *http.Request) {
+		n := seq.Add(1)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="covcounters.abc.7.%d"`, n))
+		fmt.Fprintf(w, "counters %d", n)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestScraper(t *testing.T) {
+	var metaServed atomic.Int32
+	a := fakeTarget(t, "pod-a", &metaServed)
+	b := fakeTarget(t, "pod-b", &metaServed)
+
+	dir := t.TempDir()
+	s := &Scraper{
+		Dir:     dir,
+		Targets: func(context.Context) ([]string, error) { return []string{a.URL, b.URL + "/"}, nil },
+	}
+	for range 2 {
+		if err := s.Scrape(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pod := range []string{"pod-a", "pod-b"} {
+		entries, err := os.ReadDir(filepath.Join(dir, "api", "v1", pod))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		// Only the newest cumulative counters are kept.
+		want := []string{"covcounters.abc.7.2", "covmeta.abc", flush.ManifestName("covcounters.abc.7.2")}
+		if !slices.Equal(names, want) {
+			t.Errorf("%s: files = %v, want %v", pod, names, want)
+		}
+	}
+	if n := metaServed.Load(); n != 2 {
+		t.Errorf("meta-data served %d times, want once per target", n)
+	}
+}
+
+func TestScraper_TargetErrors(t *testing.T) {
+	var metaServed atomic.Int32
+	ok := fakeTarget(t, "pod-a", &metaServed)
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	dir := t.TempDir()
+	s := &Scraper{
+		Dir:     dir,
+		Targets: func(context.Context) ([]string, error) { return []string{down.URL, ok.URL}, nil },
+	}
+	err := s.Scrape(context.Background())
+	if err == nil || !strings.Contains(err.Error(), down.URL) || strings.Contains(err.Error(), ok.URL) {
+		t.Errorf("err = %v, want only %s to fail", err, down.URL)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "api", "v1", "pod-a", "covmeta.abc")); err != nil {
+		t.Errorf("healthy target not stored: %v", err)
+	}
+}
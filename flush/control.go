@@ -0,0 +1,114 @@
+package flush
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Commands accepted on Config.ControlSocket.
+const (
+	ControlFlush  = "flush"
+	ControlClear  = "clear"
+	ControlStatus = "status"
+)
+
+// controlTimeout bounds a control connection's idle time and each flush it
+// triggers.
+const controlTimeout = 5 * time.Minute
+
+// controlResponse is the JSON line written for every control command.
+type controlResponse struct {
+	OK     bool    `json:"ok"`
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"` // for ControlStatus
+}
+
+// listenControl listens on the Unix socket path, replacing a stale socket
+// left by a process that did not stop cleanly. The socket is accessible to
+// the owner only. A path that exists and is not a socket is left alone.
+func listenControl(path string) (net.Listener, error) {
+	ln, err := listenUnix(path)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		if info, statErr := os.Lstat(path); statErr == nil && info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("goreach/flush: control socket %s exists and is not a socket", path)
+		}
+		if conn, dialErr := net.DialTimeout("unix", path, time.Second); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("goreach/flush: control socket %s is in use", path)
+		}
+		if rmErr := os.Remove(path); rmErr != nil {
+			return nil, fmt.Errorf("goreach/flush: remove stale control socket: %w", rmErr)
+		}
+		ln, err = listenUnix(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("goreach/flush: listen on control socket: %w", err)
+	}
+	return ln, nil
+}
+
+// serveControl accepts control connections until ln is closed.
+func (f *Flusher) serveControl(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handleControl(conn)
+	}
+}
+
+// handleControl executes the commands read from conn, one per line, and
+// answers each with a JSON line.
+func (f *Flusher) handleControl(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		conn.SetDeadline(time.Now().Add(controlTimeout))
+		if !sc.Scan() {
+			return
+		}
+		cmd := strings.TrimSpace(sc.Text())
+		if cmd == "" {
+			continue
+		}
+		if err := enc.Encode(f.control(cmd)); err != nil {
+			return
+		}
+	}
+}
+
+// control executes a single control command.
+func (f *Flusher) control(cmd string) controlResponse {
+	var err error
+	switch cmd {
+	case ControlFlush:
+		ctx, cancel := context.WithTimeout(f.ctx, controlTimeout)
+		err = f.EmitContext(ctx)
+		cancel()
+	case ControlClear:
+		err = clearCounters()
+	case ControlStatus:
+		st := f.Status()
+		return controlResponse{OK: true, Status: &st}
+	default:
+		err = fmt.Errorf("unknown command %q (want %s, %s or %s)", cmd, ControlFlush, ControlClear, ControlStatus)
+	}
+	if l := f.cfg.Logger; l != nil {
+		l.Info("goreach: control command", "service", f.cfg.ServiceName, "command", cmd, "error", err)
+	}
+	if err != nil {
+		return controlResponse{Error: err.Error()}
+	}
+	return controlResponse{OK: true}
+}
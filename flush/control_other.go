@@ -0,0 +1,11 @@
+//go:build !unix
+
+package flush
+
+import "net"
+
+// listenUnix listens on the Unix socket path. Access is governed by the
+// permissions of the directory containing it.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
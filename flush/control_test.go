@@ -0,0 +1,135 @@
+package flush
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// controlSocketPath returns a socket path short enough for the sun_path
+// limit of every platform.
+func controlSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "goreach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "ctl.sock")
+}
+
+func TestControlSocket(t *testing.T) {
+	fakeCoverage(t)
+	cleared := false
+	clearCounters = func() error { cleared = true; return nil }
+
+	sock := controlSocketPath(t)
+	dir := t.TempDir()
+	f := New(Config{Storage: LocalStorage{Dir: dir}, ServiceName: "worker", ControlSocket: sock})
+
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket mode = %v, want 0600", perm)
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(cmd string) controlResponse {
+		t.Helper()
+		if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp controlResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		return resp
+	}
+
+	if resp := send(ControlFlush); !resp.OK {
+		t.Fatalf("flush: %+v", resp)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "covmeta."+fakeMetaHash)); err != nil {
+		t.Errorf("flush did not store meta-data: %v", err)
+	}
+	if resp := send(ControlClear); !resp.OK || !cleared {
+		t.Errorf("clear: %+v, cleared = %v", resp, cleared)
+	}
+	resp := send(ControlStatus)
+	if !resp.OK || resp.Status == nil || resp.Status.ServiceName != "worker" || resp.Status.Stats.Flushes != 1 {
+		t.Errorf("status: %+v", resp)
+	}
+	if resp := send("reboot"); resp.OK || resp.Error == "" {
+		t.Errorf("unknown command: %+v", resp)
+	}
+
+	f.Stop()
+	if _, err := os.Stat(sock); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket not removed by Stop: %v", err)
+	}
+}
+
+func TestControlSocket_Stale(t *testing.T) {
+	fakeCoverage(t)
+	sock := controlSocketPath(t)
+
+	// A socket file without a listener, as left by a killed process.
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	f := New(Config{Storage: LocalStorage{Dir: t.TempDir()}, ControlSocket: sock})
+	defer f.Stop()
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	conn.Close()
+
+	// A socket in use is not taken over.
+	var gotErr error
+	g := New(Config{Storage: LocalStorage{Dir: t.TempDir()}, ControlSocket: sock, OnError: func(err error) { gotErr = err }})
+	defer g.Stop()
+	if gotErr == nil {
+		t.Error("expected OnError for a control socket in use")
+	}
+}
+
+// TestControlSocket_NotSocket tests that a file in the way of the control
+// socket is reported and left alone.
+func TestControlSocket_NotSocket(t *testing.T) {
+	fakeCoverage(t)
+	path := controlSocketPath(t)
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotErr error
+	f := New(Config{Storage: LocalStorage{Dir: t.TempDir()}, ControlSocket: path, OnError: func(err error) { gotErr = err }})
+	f.Stop()
+	if gotErr == nil || !strings.Contains(gotErr.Error(), "not a socket") {
+		t.Errorf("OnError got %v, want not a socket", gotErr)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("file = %q, %v; want it untouched", data, err)
+	}
+}
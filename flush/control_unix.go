@@ -0,0 +1,17 @@
+//go:build unix
+
+package flush
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on the Unix socket path. The umask makes the socket
+// accessible to the owner only from the moment it is created. New calls it
+// before starting any goroutine of its own.
+func listenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -1,14 +1,22 @@
 package flush
 
 import (
+	"bytes"
 	"context"
+	"expvar"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime/coverage"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/yag13s/goreach/internal/covmeta"
 )
 
 // Config configures the coverage flush behavior.
@@ -17,47 +25,116 @@ type Config struct {
 	// GOCOVERDIR environment variable is used as a fallback.
 	Storage Storage
 
-	// ServiceName identifies the service producing coverage data.
+	// ServiceName identifies the service producing coverage data. If empty,
+	// the last element of the main package path is used.
 	ServiceName string
 
 	// BuildVersion is the build version or commit hash. Coverage data from
 	// different build versions must not be merged (covmeta incompatibility).
+	// If empty, it is taken from the VCS revision in the binary's build info,
+	// or derived from the covmeta hash as a last resort.
 	BuildVersion string
 
+	// BuildTime is the time of the build's commit. If zero, it is taken from
+	// the VCS time in the binary's build info.
+	BuildTime time.Time
+
+	// Labels are attached to every flush's Metadata, e.g. region, cohort or
+	// tenant, so that coverage can be selected by them at analysis time.
+	Labels map[string]string
+
+	// LabelEnv maps label names to environment variables whose values are
+	// captured as labels at flush time. Unset variables are skipped, and
+	// Labels takes precedence over LabelEnv for the same name.
+	LabelEnv map[string]string
+
 	// Interval sets the periodic flush interval. Zero disables periodic flush.
 	Interval time.Duration
 
+	// InitialDelay delays the first periodic flush. Zero waits one Interval.
+	InitialDelay time.Duration
+
+	// Jitter adds a random delay in [0, Jitter) to every periodic flush, so
+	// that pods started by the same rollout do not upload at the same time.
+	Jitter time.Duration
+
+	// Align schedules periodic flushes at wall-clock multiples of Interval
+	// (e.g. the top of every hour for time.Hour), so that flush windows are
+	// comparable across pods. Jitter is added after alignment.
+	Align bool
+
+	// MinInterval coalesces Emit calls: an Emit within MinInterval of the end
+	// of the previous flush returns that flush's result without flushing
+	// again. Periodic, signal and final flushes are not affected.
+	MinInterval time.Duration
+
 	// Clear resets coverage counters after each flush (atomic mode only).
 	Clear bool
-}
 
-var (
-	mu      sync.Mutex
-	state   *flushState
-	enabled bool
-)
-
-type flushState struct {
-	cfg    Config
-	stopCh chan struct{}
-	doneCh chan struct{}
-	sigCh  chan os.Signal
+	// OnError is called with the error of every failed background flush:
+	// periodic, signal-triggered, and the final flush in Stop. Errors from
+	// Emit and StopContext are returned to the caller instead.
+	OnError func(error)
+
+	// ControlSocket is the path of a Unix domain socket on which the
+	// Flusher accepts the commands flush, clear and status, one per line,
+	// and answers each with a line of JSON: {"ok":true}, {"ok":false,
+	// "error":"..."} or, for status, {"ok":true,"status":{...}}. The socket
+	// is created with mode 0600 and removed by Stop. goreach ctl is a client.
+	// Empty disables the control socket.
+	ControlSocket string
+
+	// Logger receives a record for every flush. Successful flushes are
+	// logged at debug level, failures at error level. Nil disables logging.
+	Logger *slog.Logger
 }
 
-// Enable activates coverage flushing with the given configuration.
-// If the binary was not built with -cover, Enable is a no-op.
-func Enable(cfg Config) {
-	if !coverageAvailable() {
-		return
-	}
+// Stats reports the flush activity of a Flusher.
+type Stats struct {
+	Flushes       uint64    `json:"flushes"`        // successful flushes
+	Failures      uint64    `json:"failures"`       // failed flushes
+	Coalesced     uint64    `json:"coalesced"`      // Emit calls answered by a recent flush (MinInterval)
+	BytesUploaded int64     `json:"bytes_uploaded"` // bytes handed to Storage by successful flushes
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitzero"`
+	LastSuccess   time.Time `json:"last_success,omitzero"`
+
+	LastFlush    time.Time     `json:"last_flush,omitzero"`     // start of the most recent flush
+	LastDuration time.Duration `json:"last_duration,omitempty"` // duration of the most recent flush, in nanoseconds
+}
 
-	mu.Lock()
-	defer mu.Unlock()
+// Flusher flushes coverage data according to its own Config. Several
+// Flushers may run side by side, e.g. a local debug dump and an object
+// store upload with different intervals.
+//
+// Coverage counters are process-wide: a Flusher with Clear set resets the
+// counters seen by every other Flusher as well.
+type Flusher struct {
+	cfg    Config
+	active bool  // false when the binary was not built with -cover
+	cfgErr error // from Config.Validate; fails every flush
 
-	if enabled {
-		return
-	}
+	mu      sync.Mutex
+	stopped bool
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	ctx     context.Context // context of background flushes, canceled when stopped
+	cancel  context.CancelFunc
+	sigCh   chan os.Signal
+	ctl     net.Listener // control socket, nil if not configured
+	stats   Stats
+
+	flushMu   sync.Mutex // serializes flushes
+	lastFlush time.Time  // end of the previous flush, guarded by flushMu
+	lastErr   error      // result of the previous flush, guarded by flushMu
+}
 
+// New creates a Flusher for cfg and starts periodic flushing if
+// cfg.Interval is set. Call Stop to perform the final flush.
+// If the binary was not built with -cover, all methods of the returned
+// Flusher are no-ops.
+func New(cfg Config) *Flusher {
+	cfg = cfg.withBuildInfo()
 	if cfg.Storage == nil {
 		dir := os.Getenv("GOCOVERDIR")
 		if dir == "" {
@@ -66,131 +143,458 @@ func Enable(cfg Config) {
 		cfg.Storage = LocalStorage{Dir: dir}
 	}
 
-	s := &flushState{
+	f := &Flusher{
 		cfg:    cfg,
+		active: coverageAvailable(),
 		stopCh: make(chan struct{}),
 		doneCh: make(chan struct{}),
 	}
-	state = s
-	enabled = true
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	if f.active {
+		if err := cfg.Validate(); err != nil {
+			f.cfgErr = err
+			if cfg.Logger != nil {
+				cfg.Logger.Error("goreach: invalid flush configuration", "service", cfg.ServiceName, "error", err)
+			}
+			if cfg.OnError != nil {
+				cfg.OnError(err)
+			}
+		}
+	}
+
+	if f.active && cfg.ControlSocket != "" {
+		ln, err := listenControl(cfg.ControlSocket)
+		if err != nil {
+			if cfg.Logger != nil {
+				cfg.Logger.Error("goreach: control socket unavailable", "service", cfg.ServiceName, "error", err)
+			}
+			if cfg.OnError != nil {
+				cfg.OnError(err)
+			}
+		} else {
+			f.ctl = ln
+			go f.serveControl(ln)
+		}
+	}
 
-	if cfg.Interval > 0 {
-		go s.periodicFlush()
+	if f.active && cfg.Interval > 0 {
+		go f.periodicFlush()
 	} else {
-		close(s.doneCh)
+		close(f.doneCh)
 	}
+	return f
 }
 
-// Stop performs a final flush and stops periodic flushing.
-// It should be called via defer after Enable.
-func Stop() {
-	mu.Lock()
-	s := state
-	if !enabled || s == nil {
-		mu.Unlock()
-		return
+// Validate checks cfg.Storage if it implements Validator. New calls it and,
+// on error, reports the error to OnError and Logger and fails every flush
+// with it; call Validate before Enable or New to fail fast instead.
+func (cfg Config) Validate() error {
+	if v, ok := cfg.Storage.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("goreach/flush: invalid storage: %w", err)
+		}
 	}
-	enabled = false
-	state = nil
-	mu.Unlock()
+	return nil
+}
 
-	close(s.stopCh)
-	<-s.doneCh
+// Stop performs a final flush and stops periodic flushing, signal
+// handling and the control socket. A failed final flush is reported to Config.OnError.
+// Calls after the first are no-ops.
+func (f *Flusher) Stop() {
+	if err := f.StopContext(context.Background()); err != nil && f.cfg.OnError != nil {
+		f.cfg.OnError(err)
+	}
+}
+
+// StopContext is like Stop but bounds shutdown by ctx, e.g. to stay within
+// Kubernetes' terminationGracePeriodSeconds. ctx is passed to Storage.Store
+// for the final flush; if it expires while a periodic flush is still
+// running, that flush is canceled and no final flush is attempted.
+// StopContext returns nil only if the final flush completed.
+// Calls after the first are no-ops and return nil.
+func (f *Flusher) StopContext(ctx context.Context) error {
+	f.mu.Lock()
+	if !f.active || f.stopped {
+		f.mu.Unlock()
+		return nil
+	}
+	f.stopped = true
+	sigCh, ctl := f.sigCh, f.ctl
+	f.mu.Unlock()
+
+	defer f.cancel()
+	close(f.stopCh)
+	if sigCh != nil {
+		signal.Stop(sigCh)
+	}
+	if ctl != nil {
+		ctl.Close()
+	}
 
-	if s.sigCh != nil {
-		signal.Stop(s.sigCh)
+	select {
+	case <-f.doneCh:
+	case <-ctx.Done():
+		f.cancel()
+		<-f.doneCh
+		return fmt.Errorf("goreach/flush: stop: %w", ctx.Err())
 	}
 
 	// Final flush
-	_ = doFlush(s.cfg)
+	return f.flush(ctx)
 }
 
 // Emit performs an immediate coverage data flush.
-func Emit() error {
-	mu.Lock()
-	s := state
-	if !enabled || s == nil {
-		mu.Unlock()
+func (f *Flusher) Emit() error {
+	return f.EmitContext(context.Background())
+}
+
+// EmitContext is like Emit, passing ctx to Storage.Store.
+func (f *Flusher) EmitContext(ctx context.Context) error {
+	f.mu.Lock()
+	running := f.active && !f.stopped
+	f.mu.Unlock()
+	if !running {
 		return nil
 	}
-	cfg := s.cfg
-	mu.Unlock()
 
-	return doFlush(cfg)
+	if f.cfg.MinInterval > 0 {
+		f.flushMu.Lock()
+		recent := !f.lastFlush.IsZero() && time.Since(f.lastFlush) < f.cfg.MinInterval
+		err := f.lastErr
+		f.flushMu.Unlock()
+		if recent {
+			f.mu.Lock()
+			f.stats.Coalesced++
+			f.mu.Unlock()
+			return err
+		}
+	}
+	return f.flush(ctx)
+}
+
+// Stats returns a snapshot of the Flusher's flush counters.
+func (f *Flusher) Stats() Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// Metadata returns the Metadata a flush by f would be stored with now.
+// Pull-based collectors use it to label data they read from the process.
+func (f *Flusher) Metadata() Metadata {
+	return f.cfg.currentMetadata()
+}
+
+// Metadata is like [Flusher.Metadata] for a Flusher created with cfg.
+func (cfg Config) Metadata() Metadata {
+	return cfg.withBuildInfo().currentMetadata()
+}
+
+// currentMetadata returns cfg.metadata, deriving the version fallback from
+// the meta-data hash of the running binary.
+func (cfg Config) currentMetadata() Metadata {
+	meta := cfg.metadata(nil)
+	if meta.BuildVersion == "" && coverageAvailable() {
+		var buf bytes.Buffer
+		if writeMeta(&buf) == nil {
+			if h, err := covmeta.ParseHeader(buf.Bytes()); err == nil {
+				meta.BuildVersion = metaHashVersion([]string{"covmeta." + h.Hash})
+			}
+		}
+	}
+	return meta
 }
 
 // HandleSignal registers signal-based flush triggers.
 // When any of the specified signals is received, a flush is performed.
-func HandleSignal(sigs ...os.Signal) {
-	mu.Lock()
-	s := state
-	if !enabled || s == nil {
-		mu.Unlock()
+func (f *Flusher) HandleSignal(sigs ...os.Signal) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.active || f.stopped {
+		return
+	}
+	if f.sigCh != nil {
+		signal.Notify(f.sigCh, sigs...)
 		return
 	}
-	mu.Unlock()
 
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, sigs...)
-	s.sigCh = ch
+	f.sigCh = ch
 
 	go func() {
 		for {
 			select {
 			case <-ch:
-				_ = doFlush(s.cfg)
-			case <-s.stopCh:
+				f.backgroundFlush()
+			case <-f.stopCh:
 				return
 			}
 		}
 	}()
 }
 
-func (s *flushState) periodicFlush() {
-	defer close(s.doneCh)
-	ticker := time.NewTicker(s.cfg.Interval)
-	defer ticker.Stop()
+func (f *Flusher) periodicFlush() {
+	defer close(f.doneCh)
+	timer := time.NewTimer(time.Until(f.cfg.nextFlush(time.Now(), true)))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			_ = doFlush(s.cfg)
-		case <-s.stopCh:
+		case <-timer.C:
+			f.backgroundFlush()
+			timer.Reset(time.Until(f.cfg.nextFlush(time.Now(), false)))
+		case <-f.stopCh:
 			return
 		}
 	}
 }
 
-func doFlush(cfg Config) error {
+// nextFlush returns the time of the next periodic flush after now, taking
+// InitialDelay (for the first flush), Align and Jitter into account.
+func (cfg Config) nextFlush(now time.Time, first bool) time.Time {
+	next := now.Add(cfg.Interval)
+	if first && cfg.InitialDelay > 0 {
+		next = now.Add(cfg.InitialDelay)
+	}
+	if cfg.Align {
+		// Next multiple of Interval, counted from the zero time, at or after
+		// the earliest allowed time (now, or now+InitialDelay).
+		earliest := now
+		if first && cfg.InitialDelay > 0 {
+			earliest = next
+		}
+		next = earliest.Truncate(cfg.Interval)
+		if next.Before(earliest) {
+			next = next.Add(cfg.Interval)
+		}
+		if !first && !next.After(now) {
+			next = next.Add(cfg.Interval)
+		}
+	}
+	if cfg.Jitter > 0 {
+		next = next.Add(rand.N(cfg.Jitter))
+	}
+	return next
+}
+
+// flush performs a flush and records its outcome in the Flusher's stats.
+func (f *Flusher) flush(ctx context.Context) error {
+	f.flushMu.Lock()
+	defer f.flushMu.Unlock()
+
+	start := time.Now()
+	var n int64
+	err := f.cfgErr
+	if err == nil {
+		n, err = doFlush(ctx, f.cfg)
+	}
+	elapsed := time.Since(start)
+	f.lastFlush, f.lastErr = time.Now(), err
+
+	f.mu.Lock()
+	f.stats.LastFlush, f.stats.LastDuration = start, elapsed
+	if err != nil {
+		f.stats.Failures++
+		f.stats.LastError = err.Error()
+		f.stats.LastErrorTime = start
+	} else {
+		f.stats.Flushes++
+		f.stats.BytesUploaded += n
+		f.stats.LastSuccess = start
+	}
+	f.mu.Unlock()
+
+	if l := f.cfg.Logger; l != nil {
+		if err != nil {
+			l.Error("goreach: coverage flush failed", "service", f.cfg.ServiceName, "duration", elapsed, "error", err)
+		} else {
+			l.Debug("goreach: coverage flushed", "service", f.cfg.ServiceName, "duration", elapsed, "bytes", n)
+		}
+	}
+	return err
+}
+
+// backgroundFlush performs a flush that has no caller to return an error
+// to and reports failures via Config.OnError.
+func (f *Flusher) backgroundFlush() {
+	if err := f.flush(f.ctx); err != nil && f.cfg.OnError != nil {
+		f.cfg.OnError(err)
+	}
+}
+
+var (
+	mu  sync.Mutex
+	std *Flusher // default Flusher managed by Enable and Stop
+)
+
+func init() {
+	// Published as a function so that the variable tracks whichever
+	// default Flusher is currently enabled.
+	expvar.Publish("goreach_flush", expvar.Func(func() any {
+		if f := Default(); f != nil {
+			return f.Stats()
+		}
+		return Stats{}
+	}))
+}
+
+// Enable activates coverage flushing with the given configuration on the
+// package-level default Flusher. Enable is a no-op if the default Flusher
+// is already enabled or the binary was not built with -cover.
+func Enable(cfg Config) {
+	if !coverageAvailable() {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if std != nil {
+		return
+	}
+	std = New(cfg)
+}
+
+// Stop performs a final flush and stops the default Flusher.
+// It should be called via defer after Enable.
+func Stop() {
+	mu.Lock()
+	f := std
+	std = nil
+	mu.Unlock()
+
+	if f != nil {
+		f.Stop()
+	}
+}
+
+// StopContext performs a final flush bounded by ctx and stops the default
+// Flusher. It returns nil only if the final flush completed.
+func StopContext(ctx context.Context) error {
+	mu.Lock()
+	f := std
+	std = nil
+	mu.Unlock()
+
+	if f == nil {
+		return nil
+	}
+	return f.StopContext(ctx)
+}
+
+// Emit performs an immediate coverage data flush on the default Flusher.
+func Emit() error {
+	return EmitContext(context.Background())
+}
+
+// EmitContext is like Emit, passing ctx to Storage.Store.
+func EmitContext(ctx context.Context) error {
+	f := Default()
+	if f == nil {
+		return nil
+	}
+	return f.EmitContext(ctx)
+}
+
+// HandleSignal registers signal-based flush triggers on the default Flusher.
+// When any of the specified signals is received, a flush is performed.
+func HandleSignal(sigs ...os.Signal) {
+	if f := Default(); f != nil {
+		f.HandleSignal(sigs...)
+	}
+}
+
+// Default returns the Flusher started by Enable, or nil if flushing is not
+// enabled.
+func Default() *Flusher {
+	mu.Lock()
+	defer mu.Unlock()
+	return std
+}
+
+// doFlush writes the current coverage data to cfg.Storage and returns the
+// number of bytes stored.
+func doFlush(ctx context.Context, cfg Config) (int64, error) {
+	if ss, ok := cfg.Storage.(StreamStorage); ok {
+		return doFlushStream(ctx, cfg, ss)
+	}
+
 	tmpDir, err := os.MkdirTemp("", "goreach-flush-*")
 	if err != nil {
-		return fmt.Errorf("goreach/flush: create temp dir: %w", err)
+		return 0, fmt.Errorf("goreach/flush: create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
 	// Write coverage meta and counters to temp dir
-	if err := coverage.WriteMetaDir(tmpDir); err != nil {
-		return fmt.Errorf("goreach/flush: write meta: %w", err)
+	if err := writeMetaDir(tmpDir); err != nil {
+		return 0, fmt.Errorf("goreach/flush: write meta: %w", err)
 	}
-	if err := coverage.WriteCountersDir(tmpDir); err != nil {
-		return fmt.Errorf("goreach/flush: write counters: %w", err)
+	if err := writeCountersDir(tmpDir); err != nil {
+		return 0, fmt.Errorf("goreach/flush: write counters: %w", err)
 	}
 
 	// Collect files written
 	entries, err := os.ReadDir(tmpDir)
 	if err != nil {
-		return fmt.Errorf("goreach/flush: read temp dir: %w", err)
+		return 0, fmt.Errorf("goreach/flush: read temp dir: %w", err)
 	}
 	var files []string
+	var counters string
+	var size int64
 	for _, e := range entries {
-		if !e.IsDir() {
-			files = append(files, filepath.Join(tmpDir, e.Name()))
+		if e.IsDir() {
+			continue
 		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, fmt.Errorf("goreach/flush: stat %s: %w", e.Name(), err)
+		}
+		if strings.HasPrefix(e.Name(), "covcounters.") {
+			counters = e.Name()
+		}
+		files = append(files, filepath.Join(tmpDir, e.Name()))
+		size += info.Size()
 	}
 	if len(files) == 0 {
-		return nil
+		return 0, nil
+	}
+
+	meta := cfg.metadata(files)
+	if counters != "" {
+		manifest, err := writeManifest(tmpDir, counters, meta)
+		if err != nil {
+			return 0, fmt.Errorf("goreach/flush: write manifest: %w", err)
+		}
+		if info, err := os.Stat(manifest); err == nil {
+			size += info.Size()
+		}
+		files = append(files, manifest)
+	}
+
+	if err := finishStore(cfg, cfg.Storage.Store(ctx, files, meta)); err != nil {
+		return 0, err
 	}
+	return size, nil
+}
 
+// finishStore clears the counters if cfg.Clear is set and the flush was
+// stored, or was accepted into a RetryStorage spool that will deliver it
+// later. Keeping the counters in the latter case would count them twice.
+// A non-nil err is returned wrapped, so spooled flushes still fail.
+func finishStore(cfg Config, err error) error {
+	if cfg.Clear && (err == nil || spooledOnly(err)) {
+		_ = clearCounters()
+	}
+	if err != nil {
+		return fmt.Errorf("goreach/flush: store: %w", err)
+	}
+	return nil
+}
+
+// metadata returns the Metadata for a flush of the given coverage files.
+func (cfg Config) metadata(files []string) Metadata {
 	hostname, _ := os.Hostname()
 	podName := os.Getenv("POD_NAME")
 	if podName == "" {
@@ -204,22 +608,50 @@ func doFlush(cfg Config) error {
 		Hostname:     hostname,
 		PodName:      podName,
 		BuildVersion: cfg.BuildVersion,
+		BuildTime:    cfg.BuildTime,
 		ServiceName:  cfg.ServiceName,
+		Labels:       cfg.labels(),
 	}
-
-	if err := cfg.Storage.Store(context.Background(), files, meta); err != nil {
-		return fmt.Errorf("goreach/flush: store: %w", err)
+	if meta.BuildVersion == "" {
+		meta.BuildVersion = metaHashVersion(files)
 	}
+	return meta
+}
 
-	if cfg.Clear {
-		_ = coverage.ClearCounters()
+// labels returns the flush labels: LabelEnv values overlaid with Labels.
+// It returns nil if there are none.
+func (cfg Config) labels() map[string]string {
+	var labels map[string]string
+	set := func(k, v string) {
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = v
 	}
-
-	return nil
+	for name, env := range cfg.LabelEnv {
+		if v := os.Getenv(env); v != "" {
+			set(name, v)
+		}
+	}
+	for k, v := range cfg.Labels {
+		set(k, v)
+	}
+	return labels
 }
 
+// The runtime/coverage entry points, replaceable in tests since they fail in
+// binaries built without -cover.
+var (
+	writeMetaDir     = coverage.WriteMetaDir
+	writeCountersDir = coverage.WriteCountersDir
+	writeMeta        = coverage.WriteMeta
+	writeCounters    = coverage.WriteCounters
+	clearCounters    = coverage.ClearCounters
+)
+
 // coverageAvailable checks if coverage instrumentation is present.
-func coverageAvailable() bool {
+// The result is computed once per process.
+var coverageAvailable = sync.OnceValue(func() bool {
 	// Try writing meta to a temp dir. If the binary was not built with -cover,
 	// WriteMetaDir returns an error.
 	tmpDir, err := os.MkdirTemp("", "goreach-check-*")
@@ -227,6 +659,6 @@ func coverageAvailable() bool {
 		return false
 	}
 	defer os.RemoveAll(tmpDir)
-	err = coverage.WriteMetaDir(tmpDir)
+	err = writeMetaDir(tmpDir)
 	return err == nil
-}
+})
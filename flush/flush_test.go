@@ -0,0 +1,510 @@
+package flush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMeta is a placeholder covmeta file: a header carrying meta-data hash
+// abc000... and atomic mode, and nothing else.
+var fakeMeta = func() []byte {
+	b := make([]byte, 56)
+	copy(b, "\x00cvm")
+	b[24] = 0xab // hash
+	b[25] = 0xc0
+	b[48] = 3 // counter mode
+	return b
+}()
+
+// fakeMetaHash is the hex meta-data hash in fakeMeta.
+const fakeMetaHash = "abc00000000000000000000000000000"
+
+// fakeCoverage makes the package behave as if the test binary were built
+// with -cover, writing small placeholder covmeta/covcounters files.
+func fakeCoverage(t *testing.T) {
+	t.Helper()
+	origAvail, origMeta, origCounters, origClear := coverageAvailable, writeMetaDir, writeCountersDir, clearCounters
+	origWriteMeta, origWriteCounters := writeMeta, writeCounters
+	t.Cleanup(func() {
+		coverageAvailable, writeMetaDir, writeCountersDir, clearCounters = origAvail, origMeta, origCounters, origClear
+		writeMeta, writeCounters = origWriteMeta, origWriteCounters
+	})
+	coverageAvailable = func() bool { return true }
+	writeMetaDir = func(dir string) error {
+		return os.WriteFile(filepath.Join(dir, "covmeta."+fakeMetaHash), fakeMeta, 0o644)
+	}
+	writeCountersDir = func(dir string) error {
+		return os.WriteFile(filepath.Join(dir, "covcounters."+fakeMetaHash+".1.2"), []byte("counters"), 0o644)
+	}
+	writeMeta = func(w io.Writer) error {
+		_, err := w.Write(fakeMeta)
+		return err
+	}
+	writeCounters = func(w io.Writer) error {
+		_, err := io.WriteString(w, "counters")
+		return err
+	}
+	clearCounters = func() error { return nil }
+}
+
+// failStorage is a Storage that always fails.
+type failStorage struct{ err error }
+
+func (s failStorage) Store(context.Context, []string, Metadata) error { return s.err }
+
+func TestNew_DefaultStorage(t *testing.T) {
+	fakeCoverage(t)
+	dir := t.TempDir()
+	t.Setenv("GOCOVERDIR", dir)
+
+	f := New(Config{})
+	ls, ok := f.cfg.Storage.(LocalStorage)
+	if !ok {
+		t.Fatalf("Storage = %T, want LocalStorage", f.cfg.Storage)
+	}
+	if ls.Dir != dir {
+		t.Errorf("Storage.Dir = %q, want GOCOVERDIR", ls.Dir)
+	}
+
+	f.Stop()
+	if len(counterFiles(t, dir)) == 0 {
+		t.Error("final flush did not write to GOCOVERDIR")
+	}
+}
+
+// counterFiles returns the covcounters files in dir.
+func counterFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "covcounters.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// manifestPath returns the path of the only flush manifest in dir, which
+// must be named after the only covcounters file.
+func manifestPath(t *testing.T, dir string) string {
+	t.Helper()
+	counters := counterFiles(t, dir)
+	if len(counters) != 1 {
+		t.Fatalf("covcounters files = %v, want 1", counters)
+	}
+	path := filepath.Join(dir, ManifestName(filepath.Base(counters[0])))
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestFlusher_Independent tests that stopping one Flusher does not affect
+// another, and that Stop is idempotent.
+func TestFlusher_Independent(t *testing.T) {
+	fakeCoverage(t)
+	aDir, bDir := t.TempDir(), t.TempDir()
+	a := New(Config{Storage: LocalStorage{Dir: aDir}, Interval: time.Hour})
+	b := New(Config{Storage: LocalStorage{Dir: bDir}, Interval: time.Hour})
+
+	a.Stop()
+	a.Stop()
+	if err := os.RemoveAll(aDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Emit(); err != nil {
+		t.Errorf("Emit after Stop: %v", err)
+	}
+	if _, err := os.Stat(aDir); !os.IsNotExist(err) {
+		t.Error("Emit after Stop flushed")
+	}
+
+	if err := b.Emit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(counterFiles(t, bDir)) == 0 {
+		t.Error("b did not flush after a was stopped")
+	}
+	b.Stop()
+}
+
+func TestStop_WithoutEnable(t *testing.T) {
+	// Package-level wrappers are no-ops when no default Flusher exists.
+	Stop()
+	if err := Emit(); err != nil {
+		t.Errorf("Emit without Enable: %v", err)
+	}
+	if Default() != nil {
+		t.Error("Default() should be nil without Enable")
+	}
+}
+
+func TestFlusher_Status(t *testing.T) {
+	fakeCoverage(t)
+
+	f := New(Config{
+		Storage:      LocalStorage{Dir: t.TempDir()},
+		ServiceName:  "api",
+		BuildVersion: "v1.2.3",
+		Labels:       map[string]string{"region": "eu"},
+		Interval:     time.Hour,
+	})
+	if err := f.Emit(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := f.Status()
+	if !st.Enabled || st.CoverMode != "atomic" {
+		t.Errorf("Enabled = %v, CoverMode = %q, want true and atomic", st.Enabled, st.CoverMode)
+	}
+	if st.ServiceName != "api" || st.BuildVersion != "v1.2.3" || st.Labels["region"] != "eu" {
+		t.Errorf("build identity = %q %q %v", st.ServiceName, st.BuildVersion, st.Labels)
+	}
+	if st.Interval != time.Hour || st.Storage != "flush.LocalStorage" {
+		t.Errorf("Interval = %v, Storage = %q", st.Interval, st.Storage)
+	}
+	if st.Stats.Flushes != 1 || st.Stats.LastFlush.IsZero() || st.Stats.LastDuration <= 0 {
+		t.Errorf("Stats = %+v", st.Stats)
+	}
+
+	f.Stop()
+	if f.Status().Enabled {
+		t.Error("Enabled should be false after Stop")
+	}
+}
+
+func TestFlusher_Stats(t *testing.T) {
+	fakeCoverage(t)
+
+	dir := t.TempDir()
+	f := New(Config{Storage: LocalStorage{Dir: dir}})
+	defer f.Stop()
+
+	if err := f.Emit(); err != nil {
+		t.Fatal(err)
+	}
+	st := f.Stats()
+	if st.Flushes != 1 || st.Failures != 0 {
+		t.Errorf("Flushes=%d Failures=%d, want 1 and 0", st.Flushes, st.Failures)
+	}
+	manifest, err := os.Stat(manifestPath(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(fakeMeta)+len("counters")) + manifest.Size(); st.BytesUploaded != want {
+		t.Errorf("BytesUploaded = %d, want %d", st.BytesUploaded, want)
+	}
+	if st.LastSuccess.IsZero() {
+		t.Error("LastSuccess should be set")
+	}
+
+	f.cfg.Storage = failStorage{err: errors.New("bucket unavailable")}
+	if err := f.Emit(); err == nil {
+		t.Fatal("expected error from failing storage")
+	}
+	st = f.Stats()
+	if st.Failures != 1 {
+		t.Errorf("Failures = %d, want 1", st.Failures)
+	}
+	if !strings.Contains(st.LastError, "bucket unavailable") {
+		t.Errorf("LastError = %q", st.LastError)
+	}
+	if st.LastErrorTime.IsZero() {
+		t.Error("LastErrorTime should be set")
+	}
+}
+
+// TestFlusher_OnError tests that errors from periodic and final flushes are
+// reported to OnError.
+func TestFlusher_OnError(t *testing.T) {
+	fakeCoverage(t)
+
+	errCh := make(chan error, 16)
+	f := New(Config{
+		Storage:  failStorage{err: errors.New("upload failed")},
+		Interval: 5 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errCh <- err:
+			default:
+			}
+		},
+	})
+
+	select {
+	case err := <-errCh:
+		if !strings.Contains(err.Error(), "upload failed") {
+			t.Errorf("OnError got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called for periodic flush")
+	}
+
+	f.Stop()
+	if st := f.Stats(); st.Failures < 2 {
+		t.Errorf("Failures = %d, want periodic and final flush failures", st.Failures)
+	}
+}
+
+func TestFlusher_Logger(t *testing.T) {
+	fakeCoverage(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	f := New(Config{Storage: failStorage{err: errors.New("boom")}, ServiceName: "svc", Logger: logger})
+	_ = f.Emit()
+	f.cfg.Storage = LocalStorage{Dir: t.TempDir()}
+	_ = f.Emit()
+	f.Stop()
+
+	out := buf.String()
+	if !strings.Contains(out, "coverage flush failed") || !strings.Contains(out, "boom") {
+		t.Errorf("missing failure record in log:\n%s", out)
+	}
+	if !strings.Contains(out, "coverage flushed") || !strings.Contains(out, "service=svc") {
+		t.Errorf("missing success record in log:\n%s", out)
+	}
+}
+
+func TestExpvar_DefaultStats(t *testing.T) {
+	fakeCoverage(t)
+
+	Enable(Config{Storage: LocalStorage{Dir: t.TempDir()}})
+	defer Stop()
+	if err := Emit(); err != nil {
+		t.Fatal(err)
+	}
+
+	v := expvar.Get("goreach_flush")
+	if v == nil {
+		t.Fatal("goreach_flush not published")
+	}
+	if !strings.Contains(v.String(), `"flushes":1`) {
+		t.Errorf("expvar = %s, want one flush", v.String())
+	}
+}
+
+func TestFlush_WritesManifest(t *testing.T) {
+	fakeCoverage(t)
+
+	dir := t.TempDir()
+	t.Setenv("GOREACH_TEST_REGION", "eu-west-1")
+	f := New(Config{
+		Storage:      LocalStorage{Dir: dir},
+		ServiceName:  "svc",
+		BuildVersion: "v1.2.3",
+		Labels:       map[string]string{"cohort": "canary"},
+		LabelEnv:     map[string]string{"region": "GOREACH_TEST_REGION", "tenant": "GOREACH_TEST_UNSET"},
+	})
+	defer f.Stop()
+	if err := f.Emit(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(manifestPath(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.ServiceName != "svc" || meta.BuildVersion != "v1.2.3" || meta.Timestamp.IsZero() || meta.PodName == "" {
+		t.Errorf("manifest = %+v", meta)
+	}
+	if want := map[string]string{"cohort": "canary", "region": "eu-west-1"}; !maps.Equal(meta.Labels, want) {
+		t.Errorf("manifest labels = %v, want %v", meta.Labels, want)
+	}
+}
+
+// TestFlush_ManifestPerFlush tests that flushes of several processes sharing
+// a directory each keep a manifest next to their own counters.
+func TestFlush_ManifestPerFlush(t *testing.T) {
+	fakeCoverage(t)
+	dir := t.TempDir()
+
+	for _, svc := range []string{"api", "worker"} {
+		f := New(Config{Storage: LocalStorage{Dir: dir}, ServiceName: svc})
+		if err := f.Emit(); err != nil {
+			t.Fatal(err)
+		}
+		f.Stop()
+	}
+
+	services := make(map[string]int)
+	for _, counters := range counterFiles(t, dir) {
+		data, err := os.ReadFile(filepath.Join(dir, ManifestName(filepath.Base(counters))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var meta Metadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			t.Fatal(err)
+		}
+		services[meta.ServiceName]++
+	}
+	if services["api"] == 0 || services["worker"] == 0 {
+		t.Errorf("manifests by service = %v, want both", services)
+	}
+}
+
+// TestFlusher_StopContext tests that the final flush receives the stop
+// context and that StopContext reports when it did not complete.
+func TestFlusher_StopContext(t *testing.T) {
+	fakeCoverage(t)
+
+	f := New(Config{Storage: blockingStorage{}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := f.StopContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StopContext = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("StopContext took %v", elapsed)
+	}
+	if err := f.StopContext(context.Background()); err != nil {
+		t.Errorf("second StopContext = %v, want nil", err)
+	}
+
+	g := New(Config{Storage: LocalStorage{Dir: t.TempDir()}})
+	if err := g.StopContext(context.Background()); err != nil {
+		t.Errorf("StopContext = %v, want nil after completed flush", err)
+	}
+}
+
+// TestFlusher_StopContext_CancelsPeriodic tests that an expired stop context
+// aborts a periodic flush that is still running.
+func TestFlusher_StopContext_CancelsPeriodic(t *testing.T) {
+	fakeCoverage(t)
+
+	started := make(chan struct{}, 1)
+	f := New(Config{
+		Storage: storageFunc(func(ctx context.Context) error {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		Interval: time.Millisecond,
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := f.StopContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StopContext = %v, want deadline exceeded", err)
+	}
+}
+
+// storageFunc adapts a function to Storage.
+type storageFunc func(ctx context.Context) error
+
+func (s storageFunc) Store(ctx context.Context, _ []string, _ Metadata) error { return s(ctx) }
+
+func TestConfig_NextFlush(t *testing.T) {
+	now := time.Date(2026, 5, 1, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		name  string
+		cfg   Config
+		first bool
+		want  time.Time
+	}{
+		{"interval", Config{Interval: time.Minute}, false, now.Add(time.Minute)},
+		{"initial delay", Config{Interval: time.Hour, InitialDelay: 5 * time.Second}, true, now.Add(5 * time.Second)},
+		{"initial delay only first", Config{Interval: time.Hour, InitialDelay: 5 * time.Second}, false, now.Add(time.Hour)},
+		{"align", Config{Interval: time.Hour, Align: true}, true, time.Date(2026, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"align after delay", Config{Interval: 15 * time.Minute, Align: true, InitialDelay: time.Hour}, true, time.Date(2026, 5, 1, 11, 30, 0, 0, time.UTC)},
+		{"align later flush", Config{Interval: time.Hour, Align: true}, false, time.Date(2026, 5, 1, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.nextFlush(now, tt.first); !got.Equal(tt.want) {
+				t.Errorf("nextFlush = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	onBoundary := time.Date(2026, 5, 1, 11, 0, 0, 0, time.UTC)
+	if got := (Config{Interval: time.Hour, Align: true}).nextFlush(onBoundary, false); !got.Equal(onBoundary.Add(time.Hour)) {
+		t.Errorf("nextFlush on boundary = %v, want next hour", got)
+	}
+
+	cfg := Config{Interval: time.Minute, Jitter: 10 * time.Second}
+	for range 100 {
+		got := cfg.nextFlush(now, false).Sub(now)
+		if got < time.Minute || got >= time.Minute+10*time.Second {
+			t.Fatalf("jittered delay = %v, want [1m, 1m10s)", got)
+		}
+	}
+}
+
+// TestFlusher_MinInterval tests that Emit calls within MinInterval of the
+// previous flush are coalesced.
+func TestFlusher_MinInterval(t *testing.T) {
+	fakeCoverage(t)
+
+	var calls int
+	f := New(Config{
+		Storage:     storageFunc(func(context.Context) error { calls++; return nil }),
+		MinInterval: time.Hour,
+	})
+	defer f.Stop()
+
+	for range 3 {
+		if err := f.Emit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Store calls = %d, want 1", calls)
+	}
+	if st := f.Stats(); st.Flushes != 1 || st.Coalesced != 2 {
+		t.Errorf("Flushes=%d Coalesced=%d, want 1 and 2", st.Flushes, st.Coalesced)
+	}
+}
+
+// invalidStorage is a Storage whose configuration is always invalid.
+type invalidStorage struct{ failStorage }
+
+func (invalidStorage) Validate() error { return errors.New("bad key template") }
+
+// TestNew_InvalidStorage tests that a Storage failing validation is reported
+// when the Flusher is created, and that flushes fail without calling Store.
+func TestNew_InvalidStorage(t *testing.T) {
+	fakeCoverage(t)
+
+	var reported []error
+	cfg := Config{
+		Storage: &MultiStorage{Destinations: []Destination{
+			{Name: "local", Storage: LocalStorage{Dir: t.TempDir()}},
+			{Name: "s3", Storage: &RetryStorage{Storage: invalidStorage{}}},
+		}},
+		OnError: func(err error) { reported = append(reported, err) },
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `destination "s3": bad key template`) {
+		t.Errorf("Validate = %v", err)
+	}
+
+	f := New(cfg)
+	defer f.Stop()
+	if len(reported) != 1 {
+		t.Fatalf("OnError called %d times at New, want 1", len(reported))
+	}
+	if err := f.Emit(); err == nil || !strings.Contains(err.Error(), "bad key template") {
+		t.Errorf("Emit = %v, want validation error", err)
+	}
+}
@@ -0,0 +1,338 @@
+package flushhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permission is a set of actions a caller may perform.
+type Permission uint8
+
+const (
+	PermRead  Permission = 1 << iota // GET endpoints
+	PermFlush                        // POST .../flush
+	PermClear                        // POST .../clear
+
+	PermAll = PermRead | PermFlush | PermClear
+)
+
+// String returns the permission names, e.g. "read,flush".
+func (p Permission) String() string {
+	var names []string
+	for _, n := range []struct {
+		p    Permission
+		name string
+	}{{PermRead, "read"}, {PermFlush, "flush"}, {PermClear, "clear"}} {
+		if p&n.p != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Token is a bearer token accepted in "Authorization: Bearer <Secret>".
+type Token struct {
+	Name        string // identifies the caller in audit logs
+	Secret      string
+	Permissions Permission
+}
+
+// HMACKey is a shared key for HMAC-signed requests; see [SignRequest].
+type HMACKey struct {
+	ID          string // sent in X-Goreach-Key-Id; identifies the caller in audit logs
+	Secret      []byte
+	Permissions Permission
+}
+
+// ClientCert grants permissions to a TLS client certificate that the
+// server verified. Name is matched against the certificate's subject common
+// name, DNS names and URIs (e.g. a SPIFFE ID).
+//
+// The http.Server must request and verify client certificates, e.g. with
+// tls.Config.ClientAuth = tls.RequireAndVerifyClientCert; unverified
+// certificates are ignored.
+type ClientCert struct {
+	Name        string
+	Permissions Permission
+}
+
+// Headers of HMAC-signed requests.
+const (
+	HeaderKeyID     = "X-Goreach-Key-Id"
+	HeaderTimestamp = "X-Goreach-Timestamp"
+	HeaderNonce     = "X-Goreach-Nonce"
+	HeaderSignature = "X-Goreach-Signature"
+)
+
+// maxSignedBody bounds the body of an HMAC-signed request, which the server
+// reads in full to verify its hash.
+const maxSignedBody = 1 << 20
+
+// SignRequest signs r with key: it sets the HMAC headers, with a fresh
+// random nonce and the signature computed as the hex HMAC-SHA256 of
+//
+//	method "\n" path "\n" query "\n" timestamp "\n" nonce "\n" hex(sha256(body))
+//
+// where path is the escaped request path, query is the canonical (sorted,
+// encoded) query string and timestamp is in Unix seconds. The server checks
+// the path the request was sent to, so the handler may be mounted behind
+// http.StripPrefix. The server rejects requests whose timestamp is more
+// than Options.MaxClockSkew away, and nonces it has already seen from the
+// same key within that window, so a captured request cannot be replayed or
+// have its query or body changed.
+//
+// The body is read and replaced so that r can still be sent.
+func SignRequest(r *http.Request, key HMACKey) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := rand.Text()
+	r.Header.Set(HeaderKeyID, key.ID)
+	r.Header.Set(HeaderTimestamp, ts)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, hmacSignature(key.Secret, r, ts, nonce, body))
+	return nil
+}
+
+func hmacSignature(secret []byte, r *http.Request, ts, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(r.Method + "\n" + signedPath(r) + "\n" + r.URL.Query().Encode() + "\n" +
+		ts + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signedPath returns the escaped path r was sent to. Server requests take it
+// from RequestURI, which http.StripPrefix leaves intact.
+func signedPath(r *http.Request) string {
+	if r.RequestURI != "" {
+		if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			return u.EscapedPath()
+		}
+	}
+	return r.URL.EscapedPath()
+}
+
+// readBody reads the body of r, up to maxSignedBody bytes, and replaces it
+// with a reader of the same bytes.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBody {
+		return nil, fmt.Errorf("goreach: signed request body exceeds %d bytes", maxSignedBody)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	return body, nil
+}
+
+// nonceCache remembers the nonces of HMAC-signed requests until their
+// timestamps expire, to reject replays.
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time // key ID + "\x00" + nonce -> expiry
+	pruned time.Time
+}
+
+// add records nonce for keyID until expires. It reports false if the nonce
+// was already recorded.
+func (c *nonceCache) add(keyID, nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	if now.Sub(c.pruned) > time.Minute {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.pruned = now
+	}
+	k := keyID + "\x00" + nonce
+	if exp, ok := c.seen[k]; ok && !now.After(exp) {
+		return false
+	}
+	c.seen[k] = expires
+	return true
+}
+
+// identity is an authenticated caller.
+type identity struct {
+	name   string // token name, HMAC key ID or certificate name
+	method string // "bearer", "hmac", "mtls" or "anonymous"
+	perms  Permission
+}
+
+// authEnabled reports whether any credentials are configured. Without
+// them, every request is allowed.
+func (o *Options) authEnabled() bool {
+	return len(o.Tokens) > 0 || len(o.HMACKeys) > 0 || len(o.ClientCerts) > 0
+}
+
+// authenticate returns the identity of the caller of r. Presented but
+// invalid credentials yield ok == false and the claimed identity, if any;
+// absent credentials yield the anonymous identity.
+func (o *Options) authenticate(r *http.Request) (id identity, ok bool) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		secret, found := strings.CutPrefix(auth, "Bearer ")
+		if !found {
+			return identity{method: "unsupported"}, false
+		}
+		for _, t := range o.Tokens {
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(t.Secret)) == 1 {
+				return identity{name: t.Name, method: "bearer", perms: t.Permissions}, true
+			}
+		}
+		return identity{method: "bearer"}, false
+	}
+
+	if keyID := r.Header.Get(HeaderKeyID); keyID != "" {
+		return o.authenticateHMAC(r, keyID)
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(o.ClientCerts) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+		for _, u := range cert.URIs {
+			names = append(names, u.String())
+		}
+		for _, c := range o.ClientCerts {
+			for _, n := range names {
+				if n != "" && n == c.Name {
+					return identity{name: c.Name, method: "mtls", perms: c.Permissions}, true
+				}
+			}
+		}
+	}
+
+	return identity{name: "anonymous", method: "anonymous", perms: o.Anonymous}, true
+}
+
+func (o *Options) authenticateHMAC(r *http.Request, keyID string) (identity, bool) {
+	claimed := identity{name: keyID, method: "hmac"} // for the audit log
+	ts, nonce := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || nonce == "" || len(nonce) > 64 {
+		return claimed, false
+	}
+	skew := o.MaxClockSkew
+	if skew <= 0 {
+		skew = 5 * time.Minute
+	}
+	signed := time.Unix(sec, 0)
+	if d := time.Since(signed); d > skew || d < -skew {
+		return claimed, false
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return claimed, false
+	}
+	for _, k := range o.HMACKeys {
+		if k.ID != keyID {
+			continue
+		}
+		want := hmacSignature(k.Secret, r, ts, nonce, body)
+		if hmac.Equal([]byte(r.Header.Get(HeaderSignature)), []byte(want)) {
+			if !o.nonces.add(keyID, nonce, signed.Add(skew)) {
+				return claimed, false // replayed
+			}
+			return identity{name: k.ID, method: "hmac", perms: k.Permissions}, true
+		}
+	}
+	return claimed, false
+}
+
+// authorize wraps h so that only callers with perm reach it, and records
+// the action in the audit log.
+func (o *Options) authorize(perm Permission, action string, h http.HandlerFunc) http.HandlerFunc {
+	if !o.authEnabled() && o.AuditLog == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := identity{name: "anonymous", method: "anonymous", perms: PermAll}
+		if o.authEnabled() {
+			var ok bool
+			if id, ok = o.authenticate(r); !ok {
+				o.audit(slog.LevelWarn, r, action, id, "invalid credentials")
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "goreach: unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if id.perms&perm == 0 {
+			o.audit(slog.LevelWarn, r, action, id, "denied")
+			status := http.StatusForbidden
+			if id.method == "anonymous" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, "goreach: "+strings.ToLower(http.StatusText(status)), status)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		level := slog.LevelInfo
+		if perm == PermRead {
+			level = slog.LevelDebug
+		}
+		o.audit(level, r, action, id, strconv.Itoa(rec.status))
+	}
+}
+
+func (o *Options) audit(level slog.Level, r *http.Request, action string, id identity, outcome string) {
+	if o.AuditLog == nil {
+		return
+	}
+	o.AuditLog.Log(context.Background(), level, "goreach: coverage "+action,
+		"caller", id.name,
+		"auth", id.method,
+		"remote", r.RemoteAddr,
+		"path", r.URL.Path,
+		"outcome", outcome,
+	)
+}
+
+// statusRecorder records the response status for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,228 @@
+package flushhttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+func TestHandlerWithOptions_Auth(t *testing.T) {
+	hmacKey := HMACKey{ID: "ci", Secret: []byte("hmac-secret"), Permissions: PermFlush}
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/qa/sa/runner")
+	opts := Options{
+		Flusher: flush.New(flush.Config{Storage: flush.LocalStorage{Dir: t.TempDir()}}),
+		Tokens: []Token{
+			{Name: "dashboard", Secret: "read-token", Permissions: PermRead},
+			{Name: "admin", Secret: "admin-token", Permissions: PermAll},
+		},
+		HMACKeys:    []HMACKey{hmacKey},
+		ClientCerts: []ClientCert{{Name: spiffe.String(), Permissions: PermRead | PermClear}},
+		Anonymous:   PermRead,
+	}
+	h := HandlerWithOptions(opts)
+
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	signed := func(key HMACKey, age time.Duration) func(*http.Request) {
+		return func(r *http.Request) {
+			if err := SignRequest(r, key); err != nil {
+				t.Fatal(err)
+			}
+			if age != 0 {
+				ts := strconv.FormatInt(time.Now().Add(-age).Unix(), 10)
+				r.Header.Set(HeaderTimestamp, ts)
+				r.Header.Set(HeaderSignature, hmacSignature(key.Secret, r, ts, r.Header.Get(HeaderNonce), nil))
+			}
+		}
+	}
+	clientCert := func(r *http.Request) {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "runner"}, URIs: []*url.URL{spiffe}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   func(*http.Request)
+		want   int // 0: any status but 401 and 403
+	}{
+		{"anonymous read", "GET", "/internal/coverage/manifest", nil, http.StatusOK},
+		{"anonymous flush", "POST", "/internal/coverage/flush", nil, http.StatusUnauthorized},
+		{"read token flush", "POST", "/internal/coverage/flush", bearer("read-token"), http.StatusForbidden},
+		{"admin token flush", "POST", "/internal/coverage/flush", bearer("admin-token"), http.StatusOK},
+		{"wrong token read", "GET", "/internal/coverage/manifest", bearer("guess"), http.StatusUnauthorized},
+		{"basic auth", "GET", "/internal/coverage/manifest", func(r *http.Request) { r.SetBasicAuth("a", "b") }, http.StatusUnauthorized},
+		{"hmac flush", "POST", "/internal/coverage/flush", signed(hmacKey, 0), http.StatusOK},
+		{"hmac clear", "POST", "/internal/coverage/clear", signed(hmacKey, 0), http.StatusForbidden},
+		{"hmac stale", "POST", "/internal/coverage/flush", signed(hmacKey, time.Hour), http.StatusUnauthorized},
+		{"hmac wrong key", "POST", "/internal/coverage/flush", signed(HMACKey{ID: "ci", Secret: []byte("guess")}, 0), http.StatusUnauthorized},
+		{"mtls clear", "POST", "/internal/coverage/clear", clientCert, 0},
+		{"mtls flush", "POST", "/internal/coverage/flush", clientCert, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth != nil {
+				tt.auth(r)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			switch {
+			case tt.want == 0 && (w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden):
+				t.Errorf("status = %d, want authorized", w.Code)
+			case tt.want != 0 && w.Code != tt.want:
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.want, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
+
+// TestHandlerWithOptions_HMACTamperReplay tests that HMAC signatures cover
+// the query and body, and that a signed request is accepted only once.
+func TestHandlerWithOptions_HMACTamperReplay(t *testing.T) {
+	key := HMACKey{ID: "ci", Secret: []byte("hmac-secret"), Permissions: PermAll}
+	h := HandlerWithOptions(Options{
+		Flusher:  flush.New(flush.Config{Storage: flush.LocalStorage{Dir: t.TempDir()}}),
+		HMACKeys: []HMACKey{key},
+	})
+	sign := func(method, target, body string) *http.Request {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if err := SignRequest(r, key); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Query parameters in any order are the same canonical query.
+	r := sign("GET", "/internal/coverage/manifest?b=2&a=1", "")
+	r.URL.RawQuery = "a=1&b=2"
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("reordered query: status = %d, want 200", code)
+	}
+
+	r = sign("GET", "/internal/coverage/manifest?format=json", "")
+	r.URL.RawQuery = "format=text"
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("tampered query: status = %d, want 401", code)
+	}
+
+	r = sign("POST", "/internal/coverage/flush", "a")
+	r.Body = io.NopCloser(strings.NewReader("b"))
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("tampered body: status = %d, want 401", code)
+	}
+
+	r = sign("POST", "/internal/coverage/flush", "")
+	replay := r.Clone(r.Context())
+	if code := serve(r); code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want 200", code)
+	}
+	if code := serve(replay); code != http.StatusUnauthorized {
+		t.Errorf("replayed request: status = %d, want 401", code)
+	}
+
+	r = sign("POST", "/internal/coverage/flush", "")
+	r.Header.Del(HeaderNonce)
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("missing nonce: status = %d, want 401", code)
+	}
+}
+
+// TestSignRequest_StripPrefix tests that signed requests verify against the
+// path they were sent to when the handler is mounted behind StripPrefix.
+func TestSignRequest_StripPrefix(t *testing.T) {
+	key := HMACKey{ID: "ci", Secret: []byte("hmac-secret"), Permissions: PermAll}
+	h := HandlerWithOptions(Options{
+		Flusher:  flush.New(flush.Config{Storage: flush.LocalStorage{Dir: t.TempDir()}}),
+		HMACKeys: []HMACKey{key},
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", http.StripPrefix("/debug", h))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func(path, sendPath string) int {
+		t.Helper()
+		r, err := http.NewRequest("GET", srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SignRequest(r, key); err != nil {
+			t.Fatal(err)
+		}
+		r.URL.Path = sendPath
+		resp, err := srv.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := do("/debug/internal/coverage/manifest", "/debug/internal/coverage/manifest"); code != http.StatusOK {
+		t.Errorf("status = %d, want 200", code)
+	}
+	if code := do("/debug/internal/coverage/manifest", "/debug/internal/coverage/meta"); code != http.StatusUnauthorized {
+		t.Errorf("tampered path: status = %d, want 401", code)
+	}
+}
+
+// TestStatusRecorder_Unwrap tests that handlers behind authorize can reach
+// the underlying ResponseWriter through http.ResponseController.
+func TestStatusRecorder_Unwrap(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if err := http.NewResponseController(rec).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Flushed {
+		t.Error("Flush did not reach the underlying ResponseWriter")
+	}
+}
+
+func TestHandlerWithOptions_AuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := HandlerWithOptions(Options{
+		Flusher:  flush.New(flush.Config{Storage: flush.LocalStorage{Dir: t.TempDir()}}),
+		Tokens:   []Token{{Name: "release-bot", Secret: "s", Permissions: PermFlush}},
+		AuditLog: slog.New(slog.NewTextHandler(&buf, nil)),
+	})
+
+	for _, token := range []string{"s", "guess"} {
+		r := httptest.NewRequest("POST", "/internal/coverage/flush", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log = %q, want 2 records", lines)
+	}
+	for i, want := range []string{
+		`level=INFO msg="goreach: coverage flush" caller=release-bot auth=bearer`,
+		`level=WARN msg="goreach: coverage flush" caller="" auth=bearer remote=192.0.2.1:1234 path=/internal/coverage/flush outcome="invalid credentials"`,
+	} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("record %d = %q, want %q", i, lines[i], want)
+		}
+	}
+}
@@ -5,54 +5,263 @@
 package flushhttp
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"runtime/coverage"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/yag13s/goreach/flush"
+	"github.com/yag13s/goreach/internal/covmeta"
 )
 
 // Handler returns an http.Handler that exposes coverage data endpoints.
 //
 // Endpoints:
 //
-//	GET  /internal/coverage       — returns current coverage data as text profile
-//	POST /internal/coverage/flush — flushes to Storage, then returns status
-//	POST /internal/coverage/clear — resets coverage counters (atomic mode only)
+//	GET  /internal/coverage          — returns the current counters in covcounters format
+//	GET  /internal/coverage/meta     — returns the covmeta file of the binary
+//	GET  /internal/coverage/counters — returns a covcounters file with the current counters
+//	GET  /internal/coverage/manifest — returns the flush Metadata as JSON
+//	GET  /internal/coverage/status   — returns the flush Status as JSON
+//	POST /internal/coverage/flush    — flushes to Storage, then returns status
+//	POST /internal/coverage/clear    — resets coverage counters (atomic mode only)
+//
+// Package flushhttp/report adds a text profile, a JSON summary and a
+// goreach analyze report of the current counters; see [Options.Endpoints].
+//
+// The meta, counters and manifest endpoints let a remote process, such as
+// goreach scrape, reconstruct a GOCOVERDIR. The meta and counters responses
+// name their file in Content-Disposition; meta has the meta-data hash as
+// ETag, so unchanged meta-data can be revalidated with If-None-Match.
+//
+// The status endpoint reports whether flushing is enabled, the cover mode,
+// build identity, interval and storage of the Flusher, and the time,
+// duration and error of its last flush.
+//
+// The flush, manifest and status endpoints use the default Flusher started
+// by [flush.Enable]. The endpoints are not access-controlled and always
+// served under /internal/coverage; see [HandlerWithOptions].
 func Handler() http.Handler {
+	return HandlerWithOptions(Options{})
+}
+
+// HandlerFor is like [Handler] but flushes via the given Flusher.
+func HandlerFor(f *flush.Flusher) http.Handler {
+	return HandlerWithOptions(Options{Flusher: f})
+}
+
+// Options configures [HandlerWithOptions].
+//
+// If Tokens, HMACKeys or ClientCerts are set, every request must be
+// authenticated by one of them, unless Anonymous grants the endpoint's
+// permission. Reading requires [PermRead], the flush endpoint [PermFlush]
+// and the clear endpoint [PermClear]. Unauthenticated requests get 401,
+// authenticated requests without the permission 403.
+type Options struct {
+	// Flusher serves the flush, manifest and status endpoints. Nil uses the
+	// default Flusher started by [flush.Enable].
+	Flusher *flush.Flusher
+
+	// Prefix is the path the endpoints are served under (default
+	// "/internal/coverage"), e.g. "/debug/coverage" for mounting the
+	// handler next to net/http/pprof.
+	Prefix string
+
+	Tokens      []Token      // bearer tokens
+	HMACKeys    []HMACKey    // keys for requests signed with SignRequest
+	ClientCerts []ClientCert // verified TLS client certificates
+
+	// Anonymous are the permissions of requests without credentials when
+	// authentication is configured, e.g. PermRead. Default: none.
+	Anonymous Permission
+
+	// MaxClockSkew bounds the age of HMAC-signed requests (default 5m), and
+	// so how long their nonces are remembered.
+	MaxClockSkew time.Duration
+
+	// AuditLog receives a record for every flush and clear (Info), every
+	// rejected request (Warn) and every read (Debug), naming the caller.
+	// Nil disables audit logging.
+	AuditLog *slog.Logger
+
+	// Endpoints are served in addition to the built-in endpoints, with the
+	// same authentication. An endpoint replaces the built-in endpoint with
+	// the same method and path.
+	Endpoints []Endpoint
+
+	nonces *nonceCache // HMAC nonces seen within MaxClockSkew
+}
+
+// HandlerWithOptions is like [Handler], configured by opts.
+func HandlerWithOptions(opts Options) http.Handler {
+	emit, metadata, status := flush.EmitContext, func() flush.Metadata {
+		if f := flush.Default(); f != nil {
+			return f.Metadata()
+		}
+		return flush.Config{}.Metadata()
+	}, flush.DefaultStatus
+	if opts.Flusher != nil {
+		emit, metadata, status = opts.Flusher.EmitContext, opts.Flusher.Metadata, opts.Flusher.Status
+	}
+
+	o := &opts
+	o.nonces = &nonceCache{}
+	endpoints := append([]Endpoint{
+		{http.MethodGet, "", PermRead, handleGet},
+		{http.MethodGet, "meta", PermRead, handleMeta},
+		{http.MethodGet, "counters", PermRead, handleCounters},
+		{http.MethodGet, "manifest", PermRead, manifestHandler(metadata)},
+		{http.MethodGet, "status", PermRead, statusHandler(status)},
+		{http.MethodPost, "flush", PermFlush, flushHandler(emit)},
+		{http.MethodPost, "clear", PermClear, handleClear},
+	}, opts.Endpoints...)
+
+	root, sub := routes(opts.Prefix)
+	handlers := make(map[string]http.HandlerFunc)
+	var patterns []string
+	for _, e := range endpoints {
+		pattern := e.Method + " " + sub + e.Path
+		if e.Path == "" {
+			pattern = e.Method + " " + root
+		}
+		if _, ok := handlers[pattern]; !ok {
+			patterns = append(patterns, pattern)
+		}
+		handlers[pattern] = o.authorize(e.Permission, e.Permission.String(), e.Handler)
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /internal/coverage", handleGet)
-	mux.HandleFunc("POST /internal/coverage/flush", handleFlush)
-	mux.HandleFunc("POST /internal/coverage/clear", handleClear)
-	return http.StripPrefix("", mux)
+	for _, p := range patterns {
+		mux.HandleFunc(p, handlers[p])
+	}
+	return mux
+}
+
+// Endpoint is an endpoint added to [HandlerWithOptions] through
+// Options.Endpoints, e.g. by package flushhttp/report.
+type Endpoint struct {
+	Method     string           // e.g. http.MethodGet
+	Path       string           // below Options.Prefix; "" is the Prefix itself
+	Permission Permission       // required when authentication is configured
+	Handler    http.HandlerFunc // served for Method and Path
+}
+
+// routes returns the mux pattern of the endpoint at prefix and the prefix
+// of the other endpoints' patterns.
+func routes(prefix string) (root, sub string) {
+	if prefix == "" {
+		prefix = "/internal/coverage"
+	}
+	root = "/" + strings.Trim(prefix, "/")
+	if root == "/" {
+		return "/{$}", "/"
+	}
+	return root, root + "/"
+}
+
+// Overridable for tests, which are not built with -cover.
+var (
+	writeMeta     = coverage.WriteMeta
+	writeCounters = coverage.WriteCounters
+)
+
+// metaData returns the covmeta data of the binary and its hash. Meta-data
+// does not change while the process runs.
+var metaData = sync.OnceValues(func() (metaFile, error) {
+	var buf bytes.Buffer
+	if err := writeMeta(&buf); err != nil {
+		return metaFile{}, err
+	}
+	h, err := covmeta.ParseHeader(buf.Bytes())
+	if err != nil {
+		return metaFile{}, err
+	}
+	return metaFile{data: buf.Bytes(), hash: h.Hash}, nil
+})
+
+type metaFile struct {
+	data []byte
+	hash string
 }
 
 func handleGet(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	if err := coverage.WriteCounters(w); err != nil {
+	var buf bytes.Buffer
+	if err := writeCounters(&buf); err != nil {
 		http.Error(w, fmt.Sprintf("goreach: write counters: %v", err), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(buf.Bytes())
 }
 
-func handleFlush(w http.ResponseWriter, r *http.Request) {
-	if err := flush.Emit(); err != nil {
-		http.Error(w, fmt.Sprintf("goreach: flush failed: %v", err), http.StatusInternalServerError)
+func handleMeta(w http.ResponseWriter, r *http.Request) {
+	m, err := metaData()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: write meta: %v", err), http.StatusInternalServerError)
 		return
 	}
+	setFileHeaders(w, "covmeta."+m.hash)
+	w.Header().Set("ETag", `"`+m.hash+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(m.data))
+}
 
-	hostname, _ := os.Hostname()
-	resp := map[string]string{
-		"status":   "ok",
-		"flushed":  time.Now().UTC().Format(time.RFC3339),
-		"hostname": hostname,
-		"pod_name": os.Getenv("POD_NAME"),
+func handleCounters(w http.ResponseWriter, r *http.Request) {
+	m, err := metaData()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: write meta: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeCounters(&buf); err != nil {
+		http.Error(w, fmt.Sprintf("goreach: write counters: %v", err), http.StatusInternalServerError)
+		return
+	}
+	setFileHeaders(w, fmt.Sprintf("covcounters.%s.%d.%d", m.hash, os.Getpid(), time.Now().UnixNano()))
+	w.Write(buf.Bytes())
+}
+
+func setFileHeaders(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+}
+
+func manifestHandler(metadata func() flush.Metadata) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metadata())
+	}
+}
+
+func statusHandler(status func() flush.Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status())
+	}
+}
+
+func flushHandler(emit func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := emit(r.Context()); err != nil {
+			http.Error(w, fmt.Sprintf("goreach: flush failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		hostname, _ := os.Hostname()
+		resp := map[string]string{
+			"status":   "ok",
+			"flushed":  time.Now().UTC().Format(time.RFC3339),
+			"hostname": hostname,
+			"pod_name": os.Getenv("POD_NAME"),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
 }
 
 func handleClear(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,112 @@
+package flushhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+func TestHandlerWithOptions_Prefix(t *testing.T) {
+	f := flush.New(flush.Config{
+		Storage:      flush.LocalStorage{Dir: t.TempDir()},
+		ServiceName:  "api",
+		BuildVersion: "v1.2.3",
+		Interval:     time.Minute,
+	})
+	defer f.Stop()
+
+	for _, tt := range []struct {
+		prefix string
+		path   string
+		want   int
+	}{
+		{"", "/internal/coverage/status", http.StatusOK},
+		{"/debug/coverage/", "/debug/coverage/status", http.StatusOK},
+		{"/debug/coverage", "/internal/coverage/status", http.StatusNotFound},
+		{"/", "/status", http.StatusOK},
+		{"/", "/other/status", http.StatusNotFound},
+	} {
+		h := HandlerWithOptions(Options{Flusher: f, Prefix: tt.prefix})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("prefix %q: GET %s = %d, want %d", tt.prefix, tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	f := flush.New(flush.Config{
+		Storage:      flush.LocalStorage{Dir: t.TempDir()},
+		ServiceName:  "api",
+		BuildVersion: "v1.2.3",
+		Interval:     time.Minute,
+	})
+	defer f.Stop()
+
+	rec := httptest.NewRecorder()
+	HandlerFor(f).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/coverage/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var st flush.Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	// The test binary is not built with -cover, so flushing is disabled.
+	if st.Enabled {
+		t.Error("Enabled = true, want false without -cover")
+	}
+	if st.ServiceName != "api" || st.BuildVersion != "v1.2.3" {
+		t.Errorf("build identity = %q %q", st.ServiceName, st.BuildVersion)
+	}
+	if st.Interval != time.Minute || st.Storage != "flush.LocalStorage" {
+		t.Errorf("Interval = %v, Storage = %q", st.Interval, st.Storage)
+	}
+}
+
+// TestHandlerWithOptions_Endpoints tests that added endpoints are served
+// under the prefix with the handler's authentication, and replace built-in
+// endpoints with the same method and path.
+func TestHandlerWithOptions_Endpoints(t *testing.T) {
+	reply := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(body)) }
+	}
+	h := HandlerWithOptions(Options{
+		Prefix: "/debug/coverage",
+		Tokens: []Token{{Secret: "s", Permissions: PermRead}},
+		Endpoints: []Endpoint{
+			{Method: http.MethodGet, Path: "", Permission: PermRead, Handler: reply("root")},
+			{Method: http.MethodGet, Path: "extra", Permission: PermRead, Handler: reply("extra")},
+			{Method: http.MethodPost, Path: "reset", Permission: PermClear, Handler: reply("reset")},
+		},
+	})
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+		body         string
+	}{
+		{http.MethodGet, "/debug/coverage", http.StatusOK, "root"},
+		{http.MethodGet, "/debug/coverage/extra", http.StatusOK, "extra"},
+		{http.MethodPost, "/debug/coverage/reset", http.StatusForbidden, ""},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Authorization", "Bearer s")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != tt.want || tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, rec.Code, rec.Body, tt.want, tt.body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/coverage/extra", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated GET extra = %d, want 401", rec.Code)
+	}
+}
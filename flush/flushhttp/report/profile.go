@@ -0,0 +1,165 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+
+	covreport "github.com/yag13s/goreach/internal/report"
+)
+
+// Response formats of GET /internal/coverage.
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatBinary = "binary"
+)
+
+var formatTypes = map[string]string{
+	formatText:   "text/plain; charset=utf-8",
+	formatJSON:   "application/json",
+	formatBinary: "application/octet-stream",
+}
+
+// negotiate returns the response format for the format query parameter, if
+// set, or else the Accept header. It returns "" if no format is acceptable.
+func negotiate(format, accept string) string {
+	if format != "" {
+		if _, ok := formatTypes[format]; ok {
+			return format
+		}
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return formatText
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var best candidate
+	for part := range strings.SplitSeq(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		var f string
+		switch mt {
+		case "text/plain", "text/*", "*/*":
+			f = formatText
+		case "application/json", "application/*":
+			f = formatJSON
+		case "application/octet-stream":
+			f = formatBinary
+		}
+		if f != "" && q > best.q {
+			best = candidate{f, q}
+		}
+	}
+	return best.format
+}
+
+func handleGet(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	w.Header().Add("Vary", "Accept")
+	if format == "" {
+		http.Error(w, "goreach: supported formats: text/plain, application/json, application/octet-stream", http.StatusNotAcceptable)
+		return
+	}
+
+	if format == formatBinary {
+		var buf bytes.Buffer
+		if err := writeCounters(&buf); err != nil {
+			http.Error(w, fmt.Sprintf("goreach: write counters: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", formatTypes[formatBinary])
+		w.Write(buf.Bytes())
+		return
+	}
+
+	profile, err := liveProfile()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if format == formatText {
+		w.Header().Set("Content-Type", formatTypes[formatText])
+		w.Write([]byte(profile))
+		return
+	}
+
+	s, err := summarize(profile)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", formatTypes[formatJSON])
+	json.NewEncoder(w).Encode(s)
+}
+
+// summary is the JSON response of GET /internal/coverage.
+type summary struct {
+	Mode     string                  `json:"mode"`
+	Total    covreport.CoverageStats `json:"total"`
+	Packages []packageSummary        `json:"packages"`
+}
+
+type packageSummary struct {
+	ImportPath string                  `json:"import_path"`
+	Total      covreport.CoverageStats `json:"total"`
+}
+
+// summarize computes the statement coverage of each package in profile.
+func summarize(profile string) (*summary, error) {
+	profiles, err := cover.ParseProfilesFromReader(strings.NewReader(profile))
+	if err != nil {
+		return nil, fmt.Errorf("parse profile: %w", err)
+	}
+
+	s := &summary{Packages: []packageSummary{}}
+	byPkg := make(map[string]*covreport.CoverageStats)
+	for _, p := range profiles {
+		s.Mode = p.Mode
+		pkg := path.Dir(p.FileName)
+		st := byPkg[pkg]
+		if st == nil {
+			st = &covreport.CoverageStats{}
+			byPkg[pkg] = st
+		}
+		for _, b := range p.Blocks {
+			st.TotalStatements += b.NumStmt
+			s.Total.TotalStatements += b.NumStmt
+			if b.Count > 0 {
+				st.CoveredStatements += b.NumStmt
+				s.Total.CoveredStatements += b.NumStmt
+			}
+		}
+	}
+	if s.Mode == "" {
+		s.Mode, _ = strings.CutPrefix(strings.TrimSpace(profile), "mode: ")
+	}
+
+	for pkg, st := range byPkg {
+		st.CoveragePercent = covreport.ComputePercent(st.CoveredStatements, st.TotalStatements)
+		s.Packages = append(s.Packages, packageSummary{ImportPath: pkg, Total: *st})
+	}
+	slices.SortFunc(s.Packages, func(a, b packageSummary) int { return strings.Compare(a.ImportPath, b.ImportPath) })
+	s.Total.CoveragePercent = covreport.ComputePercent(s.Total.CoveredStatements, s.Total.TotalStatements)
+	return s, nil
+}
@@ -0,0 +1,173 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yag13s/goreach/flush/flushhttp"
+	"github.com/yag13s/goreach/internal/covparse"
+	covreport "github.com/yag13s/goreach/internal/report"
+)
+
+// covdataDir holds the meta-data and counters of a real -cover build.
+const (
+	covdataDir  = "../../../internal/covparse/testdata/covdata"
+	covdataHash = "3ce4e58830a5b292ef51542c36b6ae57"
+)
+
+// newHandler returns a flushhttp handler serving the endpoints of this package.
+func newHandler() http.Handler {
+	return flushhttp.HandlerWithOptions(flushhttp.Options{Endpoints: Endpoints()})
+}
+
+// fakeCoverage makes the handlers serve the coverage data in covdataDir, as
+// if the test binary were built with -cover. It returns the counters.
+func fakeCoverage(t *testing.T) []byte {
+	t.Helper()
+	meta, err := os.ReadFile(filepath.Join(covdataDir, "covmeta."+covdataHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := os.ReadFile(filepath.Join(covdataDir, "covcounters."+covdataHash+".6591.1792124581563684758"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	origMeta, origCounters, origFuncs := metaData, writeCounters, funcRanges
+	t.Cleanup(func() { metaData, writeCounters, funcRanges = origMeta, origCounters, origFuncs })
+	metaData = func() ([]byte, error) { return meta, nil }
+	funcRanges = func() ([]covparse.FuncRange, error) { return covparse.MetaFuncRanges(meta) }
+	writeCounters = func(w io.Writer) error {
+		_, err := w.Write(counters)
+		return err
+	}
+	return counters
+}
+
+func TestHandleGet(t *testing.T) {
+	counters := fakeCoverage(t)
+	h := newHandler()
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   int
+		format string
+	}{
+		{"no accept", "/internal/coverage", "", http.StatusOK, formatText},
+		{"any", "/internal/coverage", "*/*", http.StatusOK, formatText},
+		{"json", "/internal/coverage", "application/json", http.StatusOK, formatJSON},
+		{"binary", "/internal/coverage", "application/octet-stream", http.StatusOK, formatBinary},
+		{"weighted", "/internal/coverage", "text/html, application/json;q=0.9, */*;q=0.1", http.StatusOK, formatJSON},
+		{"format overrides accept", "/internal/coverage?format=json", "application/octet-stream", http.StatusOK, formatJSON},
+		{"unacceptable", "/internal/coverage", "image/png", http.StatusNotAcceptable, ""},
+		{"unknown format", "/internal/coverage?format=xml", "", http.StatusNotAcceptable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Header().Get("Vary") != "Accept" {
+				t.Errorf("Vary = %q, want Accept", rec.Header().Get("Vary"))
+			}
+			if tt.format == "" {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != formatTypes[tt.format] {
+				t.Errorf("Content-Type = %q, want %q", ct, formatTypes[tt.format])
+			}
+			switch tt.format {
+			case formatText:
+				if body := rec.Body.String(); !strings.HasPrefix(body, "mode: atomic\n") || !strings.Contains(body, "example.com/covsample/main.go:") {
+					t.Errorf("text profile = %q", body)
+				}
+			case formatJSON:
+				var s summary
+				if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+					t.Fatal(err)
+				}
+				if s.Mode != "atomic" || len(s.Packages) != 1 || s.Packages[0].ImportPath != "example.com/covsample" || s.Total.TotalStatements == 0 {
+					t.Errorf("summary = %+v", s)
+				}
+			case formatBinary:
+				if !bytes.Equal(rec.Body.Bytes(), counters) {
+					t.Errorf("binary body differs from the counters (%d bytes)", rec.Body.Len())
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		format, accept string
+		want           string
+	}{
+		{"", "", formatText},
+		{"", "*/*", formatText},
+		{"", "text/plain", formatText},
+		{"", "application/json", formatJSON},
+		{"", "application/octet-stream", formatBinary},
+		{"", "text/html, application/json;q=0.9, */*;q=0.1", formatJSON},
+		{"", "application/octet-stream;q=0.5, text/plain", formatText},
+		{"", "image/png", ""},
+		{"json", "application/octet-stream", formatJSON},
+		{"binary", "", formatBinary},
+		{"xml", "", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiate(tt.format, tt.accept); got != tt.want {
+			t.Errorf("negotiate(%q, %q) = %q, want %q", tt.format, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	profile := `mode: atomic
+example.com/svc/api/handler.go:10.2,12.3 2 5
+example.com/svc/api/handler.go:14.2,15.3 3 0
+example.com/svc/api/routes.go:3.2,4.3 1 1
+example.com/svc/db/db.go:5.2,9.3 4 0
+`
+	s, err := summarize(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode != "atomic" {
+		t.Errorf("Mode = %q, want atomic", s.Mode)
+	}
+	if want := (covreport.CoverageStats{TotalStatements: 10, CoveredStatements: 3, CoveragePercent: 30}); s.Total != want {
+		t.Errorf("Total = %+v, want %+v", s.Total, want)
+	}
+	if len(s.Packages) != 2 {
+		t.Fatalf("expected 2 packages, got %+v", s.Packages)
+	}
+	if p := s.Packages[0]; p.ImportPath != "example.com/svc/api" || p.Total.TotalStatements != 6 || p.Total.CoveredStatements != 3 {
+		t.Errorf("Packages[0] = %+v", p)
+	}
+	if p := s.Packages[1]; p.ImportPath != "example.com/svc/db" || p.Total.CoveragePercent != 0 {
+		t.Errorf("Packages[1] = %+v", p)
+	}
+
+	empty, err := summarize("mode: set\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Mode != "set" || len(empty.Packages) != 0 {
+		t.Errorf("summarize(empty) = %+v", empty)
+	}
+}
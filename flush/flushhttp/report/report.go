@@ -0,0 +1,151 @@
+// Package report adds human-readable coverage endpoints to
+// flushhttp.HandlerWithOptions:
+//
+//	GET /internal/coverage        — returns current coverage as text profile, JSON summary or binary counters
+//	GET /internal/coverage/report — returns a goreach analyze report of the current counters
+//
+// GET /internal/coverage picks its format from the Accept header:
+// text/plain (the default, also for */*) returns a "mode: ..." text profile
+// as written by go tool covdata textfmt, application/json a per-package
+// statement summary and application/octet-stream the covcounters data. The
+// format query parameter (text, json or binary) overrides Accept.
+//
+// GET /internal/coverage/report analyzes the current counters in-process,
+// taking function names and ranges from the coverage meta-data instead of
+// the source. It accepts the pkg, threshold and min-statements parameters
+// of goreach analyze, and pretty.
+//
+// The endpoints decode coverage data in the serving process, which is why
+// they live apart from package flushhttp:
+//
+//	mux.Handle("/internal/coverage/", flushhttp.HandlerWithOptions(flushhttp.Options{
+//		Endpoints: report.Endpoints(),
+//	}))
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/coverage"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/cover"
+
+	"github.com/yag13s/goreach/flush/flushhttp"
+	"github.com/yag13s/goreach/internal/analysis"
+	"github.com/yag13s/goreach/internal/covparse"
+)
+
+// Endpoints returns the endpoints of this package, for
+// flushhttp.Options.Endpoints. They require flushhttp.PermRead.
+func Endpoints() []flushhttp.Endpoint {
+	return []flushhttp.Endpoint{
+		{Method: http.MethodGet, Path: "", Permission: flushhttp.PermRead, Handler: handleGet},
+		{Method: http.MethodGet, Path: "report", Permission: flushhttp.PermRead, Handler: handleReport},
+	}
+}
+
+// Overridable for tests, which are not built with -cover.
+var (
+	writeCounters = coverage.WriteCounters
+
+	// metaData returns the covmeta data of the binary. Meta-data does not
+	// change while the process runs.
+	metaData = sync.OnceValues(func() ([]byte, error) {
+		var buf bytes.Buffer
+		if err := coverage.WriteMeta(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+
+	// funcRanges returns the function ranges of the binary's meta-data.
+	funcRanges = sync.OnceValues(func() ([]covparse.FuncRange, error) {
+		meta, err := metaData()
+		if err != nil {
+			return nil, fmt.Errorf("write meta: %w", err)
+		}
+		return covparse.MetaFuncRanges(meta)
+	})
+)
+
+// liveProfile returns the text profile of the current counters.
+func liveProfile() (string, error) {
+	meta, err := metaData()
+	if err != nil {
+		return "", fmt.Errorf("write meta: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := writeCounters(&buf); err != nil {
+		return "", fmt.Errorf("write counters: %w", err)
+	}
+	return covparse.ParseData(meta, buf.Bytes())
+}
+
+// handleReport serves a goreach analyze report of the live counters. The
+// query parameters pkg, threshold and min-statements match the flags of
+// goreach analyze; pretty indents the JSON.
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	opts, pretty, err := reportOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	funcs, err := funcRanges()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: %v", err), http.StatusInternalServerError)
+		return
+	}
+	profile, err := liveProfile()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: %v", err), http.StatusInternalServerError)
+		return
+	}
+	profiles, err := cover.ParseProfilesFromReader(strings.NewReader(profile))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("goreach: parse profile: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rpt := analysis.RunMeta(profiles, funcs, opts)
+	rpt.GeneratedAt = time.Now().UTC()
+	w.Header().Set("Content-Type", "application/json")
+	rpt.Write(w, pretty)
+}
+
+// reportOptions parses the query parameters of the report endpoint.
+func reportOptions(q url.Values) (analysis.Options, bool, error) {
+	opts := analysis.Options{Threshold: 100}
+	if pkg := q.Get("pkg"); pkg != "" {
+		opts.PkgPrefixes = strings.Split(pkg, ",")
+	}
+	if s := q.Get("threshold"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return opts, false, fmt.Errorf("invalid threshold %q", s)
+		}
+		opts.Threshold = v
+	}
+	if s := q.Get("min-statements"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, false, fmt.Errorf("invalid min-statements %q", s)
+		}
+		opts.MinStatements = v
+	}
+	var pretty bool
+	if s := q.Get("pretty"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return opts, false, fmt.Errorf("invalid pretty %q", s)
+		}
+		pretty = v
+	}
+	return opts, pretty, nil
+}
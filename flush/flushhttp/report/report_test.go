@@ -0,0 +1,98 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	covreport "github.com/yag13s/goreach/internal/report"
+)
+
+func TestReportOptions(t *testing.T) {
+	q, _ := url.ParseQuery("pkg=example.com/a,example.com/b&threshold=80&min-statements=3&pretty=1")
+	opts, pretty, err := reportOptions(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(opts.PkgPrefixes, []string{"example.com/a", "example.com/b"}) {
+		t.Errorf("PkgPrefixes = %v", opts.PkgPrefixes)
+	}
+	if opts.Threshold != 80 || opts.MinStatements != 3 || !pretty {
+		t.Errorf("opts = %+v, pretty = %v", opts, pretty)
+	}
+
+	opts, pretty, err = reportOptions(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Threshold != 100 || opts.MinStatements != 0 || opts.PkgPrefixes != nil || pretty {
+		t.Errorf("defaults = %+v, pretty = %v", opts, pretty)
+	}
+
+	for _, bad := range []string{"threshold=high", "min-statements=1.5", "pretty=maybe"} {
+		q, _ := url.ParseQuery(bad)
+		if _, _, err := reportOptions(q); err == nil {
+			t.Errorf("reportOptions(%q): expected error", bad)
+		}
+	}
+}
+
+func TestHandleReport(t *testing.T) {
+	fakeCoverage(t)
+	h := newHandler()
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/internal/coverage/report?threshold=100")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if strings.Count(strings.TrimSpace(rec.Body.String()), "\n") != 0 {
+		t.Error("report is indented without pretty")
+	}
+	var rpt covreport.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &rpt); err != nil {
+		t.Fatal(err)
+	}
+	if rpt.Mode != "atomic" || rpt.GeneratedAt.IsZero() || rpt.Total.TotalStatements == 0 {
+		t.Errorf("report = %+v", rpt)
+	}
+	if len(rpt.Packages) != 1 || rpt.Packages[0].ImportPath != "example.com/covsample" || len(rpt.Packages[0].Files) == 0 {
+		t.Fatalf("packages = %+v", rpt.Packages)
+	}
+	if fns := rpt.Packages[0].Files[0].Functions; len(fns) == 0 || fns[0].Name == "" {
+		t.Errorf("functions = %+v", fns)
+	}
+
+	rec = get("/internal/coverage/report?pretty=1")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "\n  \"mode\": \"atomic\"") {
+		t.Errorf("pretty report: status = %d, body = %q", rec.Code, rec.Body)
+	}
+
+	rec = get("/internal/coverage/report?pkg=example.com/other")
+	rpt = covreport.Report{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rpt); err != nil {
+		t.Fatal(err)
+	}
+	if len(rpt.Packages) != 0 {
+		t.Errorf("pkg filter: packages = %+v, want none", rpt.Packages)
+	}
+
+	for _, bad := range []string{"threshold=high", "min-statements=1.5"} {
+		rec := get("/internal/coverage/report?" + bad)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid") {
+			t.Errorf("%s: status = %d, body = %q; want 400", bad, rec.Code, rec.Body)
+		}
+	}
+}
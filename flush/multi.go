@@ -0,0 +1,124 @@
+package flush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Destination is one target of a MultiStorage.
+type Destination struct {
+	// Name identifies the destination in errors. Defaults to "destination[i]".
+	Name string
+
+	// Storage receives the flushed files (required).
+	Storage Storage
+
+	// Required makes a failure of this destination fail the whole Store.
+	// Failures of best-effort destinations are reported to
+	// MultiStorage.OnError instead.
+	Required bool
+
+	// Timeout bounds a single Store call on this destination. Zero means no
+	// timeout beyond the caller's context.
+	Timeout time.Duration
+}
+
+// MultiStorage delivers each flush to several Storages concurrently, e.g. a
+// local GOCOVERDIR for on-host debugging and object storage.
+type MultiStorage struct {
+	Destinations []Destination
+
+	// OnError is called with failures of best-effort destinations, which are
+	// otherwise not reported. Nil discards them.
+	OnError func(err error)
+}
+
+// compile-time check
+var (
+	_ Storage   = (*MultiStorage)(nil)
+	_ Validator = (*MultiStorage)(nil)
+)
+
+// Validate validates every destination and returns their joined
+// DestinationErrors.
+func (m *MultiStorage) Validate() error {
+	var errs []error
+	for i, d := range m.Destinations {
+		var err error
+		if d.Storage == nil {
+			err = errors.New("nil Storage")
+		} else if v, ok := d.Storage.(Validator); ok {
+			err = v.Validate()
+		}
+		if err != nil {
+			errs = append(errs, &DestinationError{Name: d.name(i), Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// name returns the destination's name for errors.
+func (d Destination) name(i int) string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return fmt.Sprintf("destination[%d]", i)
+}
+
+// DestinationError is the error of a single MultiStorage destination.
+type DestinationError struct {
+	Name string
+	Err  error
+}
+
+func (e *DestinationError) Error() string {
+	return fmt.Sprintf("destination %q: %v", e.Name, e.Err)
+}
+
+func (e *DestinationError) Unwrap() error { return e.Err }
+
+// Store delivers files to all destinations and waits for them to finish.
+// It returns the joined DestinationErrors of the failed required
+// destinations, or nil if all required destinations succeeded.
+func (m *MultiStorage) Store(ctx context.Context, files []string, meta Metadata) error {
+	errs := make([]error, len(m.Destinations))
+	var wg sync.WaitGroup
+	for i, d := range m.Destinations {
+		wg.Go(func() {
+			if err := storeDestination(ctx, d, files, meta); err != nil {
+				errs[i] = &DestinationError{Name: d.name(i), Err: err}
+			}
+		})
+	}
+	wg.Wait()
+
+	var required []error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+		case m.Destinations[i].Required:
+			required = append(required, err)
+		case m.OnError != nil:
+			m.OnError(err)
+		}
+	}
+	if len(required) > 0 {
+		return fmt.Errorf("goreach/flush: multi: %w", errors.Join(required...))
+	}
+	return nil
+}
+
+func storeDestination(ctx context.Context, d Destination, files []string, meta Metadata) error {
+	if d.Storage == nil {
+		return errors.New("nil Storage")
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	return d.Storage.Store(ctx, files, meta)
+}
@@ -0,0 +1,68 @@
+package flush
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingStorage waits for its context to be done.
+type blockingStorage struct{}
+
+func (blockingStorage) Store(ctx context.Context, _ []string, _ Metadata) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestMultiStorage_AllDestinations(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	m := &MultiStorage{Destinations: []Destination{
+		{Name: "a", Storage: LocalStorage{Dir: a}, Required: true},
+		{Name: "b", Storage: LocalStorage{Dir: b}},
+	}}
+	if err := m.Store(context.Background(), writeFlushFiles(t, "c1"), Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{a, b} {
+		if _, err := os.Stat(filepath.Join(dir, "covcounters.abc.1.2")); err != nil {
+			t.Errorf("missing file in %s: %v", dir, err)
+		}
+	}
+}
+
+// TestMultiStorage_Policies tests that only required destinations fail the
+// Store, and that best-effort failures go to OnError.
+func TestMultiStorage_Policies(t *testing.T) {
+	var bestEffort []error
+	m := &MultiStorage{
+		Destinations: []Destination{
+			{Name: "local", Storage: LocalStorage{Dir: t.TempDir()}, Required: true},
+			{Name: "debug", Storage: failStorage{err: errors.New("disk full")}},
+			{Name: "s3", Storage: blockingStorage{}, Required: true, Timeout: 10 * time.Millisecond},
+		},
+		OnError: func(err error) { bestEffort = append(bestEffort, err) },
+	}
+
+	err := m.Store(context.Background(), writeFlushFiles(t, "c1"), Metadata{})
+	if err == nil {
+		t.Fatal("expected error from required destination")
+	}
+	if !strings.Contains(err.Error(), `destination "s3"`) || strings.Contains(err.Error(), "debug") {
+		t.Errorf("err = %v, want only s3 named", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	var de *DestinationError
+	if !errors.As(err, &de) || de.Name != "s3" {
+		t.Errorf("errors.As DestinationError = %+v", de)
+	}
+
+	if len(bestEffort) != 1 || !strings.Contains(bestEffort[0].Error(), `destination "debug": disk full`) {
+		t.Errorf("OnError got %v", bestEffort)
+	}
+}
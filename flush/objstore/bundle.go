@@ -0,0 +1,52 @@
+package objstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// bundleName returns the file name of the bundle for a flush. covparse
+// recognizes bundles by the "covbundle." prefix and ".tar.gz" suffix.
+func bundleName(meta flush.Metadata) string {
+	return fmt.Sprintf("covbundle.%d.tar.gz", meta.Timestamp.UnixNano())
+}
+
+// storeBundle packs files into a single gzip-compressed tar and uploads it.
+// Bundles are self-contained: covmeta is always included.
+func (s *Storage) storeBundle(ctx context.Context, files []flush.StreamFile, meta flush.Metadata, keyFn func(flush.Metadata, string) string) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.Name,
+			Mode:    0o644,
+			Size:    f.Size,
+			ModTime: meta.Timestamp,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("goreach/flush: bundle %s: %w", f.Name, err)
+		}
+		if _, err := io.Copy(tw, f.Body); err != nil {
+			return fmt.Errorf("goreach/flush: bundle %s: %w", f.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("goreach/flush: bundle: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("goreach/flush: bundle: %w", err)
+	}
+
+	name := bundleName(meta)
+	if err := s.Upload(ctx, keyFn(meta, name), &buf); err != nil {
+		return fmt.Errorf("goreach/flush: upload %s: %w", name, err)
+	}
+	return nil
+}
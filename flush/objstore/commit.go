@@ -0,0 +1,66 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// commitMarker is the content of a commit marker object. covparse reads the
+// same format.
+type commitMarker struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Files     []markerFile `json:"files"`
+}
+
+type markerFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// isCoverageFile reports whether name is a covmeta or covcounters file.
+// Only these are listed in commit markers: a flush's manifest only
+// describes its covcounters file and is uploaded before the marker too.
+func isCoverageFile(name string) bool {
+	return strings.HasPrefix(name, "covmeta.") || strings.HasPrefix(name, "covcounters.")
+}
+
+// digest computes the size and SHA-256 of the data written to it.
+type digest struct {
+	h hash.Hash
+	n int64
+}
+
+func newDigest() *digest { return &digest{h: sha256.New()} }
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
+
+func (d *digest) file(name string) markerFile {
+	return markerFile{Name: name, Size: d.n, SHA256: hex.EncodeToString(d.h.Sum(nil))}
+}
+
+// storeMarker uploads the commit marker of a flush.
+func (s *Storage) storeMarker(ctx context.Context, marker commitMarker, meta flush.Metadata, keyFn func(flush.Metadata, string) string) error {
+	marker.Timestamp = meta.Timestamp
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("goreach/flush: commit marker: %w", err)
+	}
+	name := fmt.Sprintf("goreach-commit.%d.json", meta.Timestamp.UnixNano())
+	if err := s.Upload(ctx, keyFn(meta, name), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("goreach/flush: upload %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// Object describes a stored object, as returned by a [Lister].
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time // zero if unknown
+}
+
+// Lister returns all objects whose keys start with prefix.
+type Lister func(ctx context.Context, prefix string) ([]Object, error)
+
+// Downloader returns the contents of the object with the given key. The
+// caller closes the returned reader.
+type Downloader func(ctx context.Context, key string) (io.ReadCloser, error)
+
+// Fetcher mirrors coverage objects written by [Storage] into a local
+// directory, so that goreach analyze -r can read them. Keys are mapped to
+// paths relative to Prefix, which with the default key layout gives
+// <dir>/<service>/<version>/<pod>/<file>.
+//
+// Fetching is incremental: objects whose local copy has the same size and
+// modification time are not downloaded again.
+type Fetcher struct {
+	List     Lister
+	Download Downloader
+	Prefix   string // key prefix (default "goreach")
+
+	// Service and Version restrict the fetch to one service and build
+	// version. They assume the default key layout.
+	Service string
+	Version string
+
+	// Since and Until restrict the fetch to objects last modified in
+	// [Since, Until). Zero values are unbounded. covmeta objects are always
+	// fetched, since they are uploaded only once per build.
+	//
+	// In directories with commit markers, the markers' times are used
+	// instead: a marker in the window is fetched together with the files it
+	// lists, whatever their own times, and coverage files listed by no
+	// such marker are skipped. This keeps every fetched flush complete.
+	Since time.Time
+	Until time.Time
+}
+
+// FetchStats summarizes a Fetch.
+type FetchStats struct {
+	Downloaded int   // objects downloaded
+	Bytes      int64 // bytes downloaded
+	UpToDate   int   // objects skipped because the local copy is current
+}
+
+// Fetch downloads the selected objects into dir. Commit markers are written
+// last, so that a concurrent reader never sees a marker before its files.
+func (f *Fetcher) Fetch(ctx context.Context, dir string) (FetchStats, error) {
+	var stats FetchStats
+	if f.List == nil || f.Download == nil {
+		return stats, errors.New("goreach/flush: fetch: List and Download are required")
+	}
+	prefix := f.Prefix
+	if prefix == "" {
+		prefix = "goreach"
+	}
+	listPrefix := prefix + "/"
+	if f.Service != "" {
+		listPrefix += f.Service + "/"
+		if f.Version != "" {
+			listPrefix += f.Version + "/"
+		}
+	}
+
+	objects, err := f.List(ctx, listPrefix)
+	if err != nil {
+		return stats, fmt.Errorf("goreach/flush: list %s: %w", listPrefix, err)
+	}
+	objects = slices.DeleteFunc(objects, func(o Object) bool { return !f.selected(prefix, o) })
+	var markers map[string][]byte
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		if objects, markers, err = f.filterTime(ctx, dir, prefix, objects); err != nil {
+			return stats, err
+		}
+	}
+	slices.SortStableFunc(objects, func(a, b Object) int {
+		am, bm := isMarkerKey(a.Key), isMarkerKey(b.Key)
+		switch {
+		case am == bm:
+			return strings.Compare(a.Key, b.Key)
+		case am:
+			return 1
+		default:
+			return -1
+		}
+	})
+
+	for _, o := range objects {
+		dst, err := localPath(dir, prefix, o.Key)
+		if err != nil {
+			return stats, err
+		}
+		if upToDate(dst, o) {
+			stats.UpToDate++
+			continue
+		}
+		var n int64
+		if data, ok := markers[o.Key]; ok {
+			n, err = writeObject(bytes.NewReader(data), o, dst)
+		} else {
+			n, err = f.fetchObject(ctx, o, dst)
+		}
+		if err != nil {
+			return stats, fmt.Errorf("goreach/flush: fetch %s: %w", o.Key, err)
+		}
+		stats.Downloaded++
+		stats.Bytes += n
+	}
+	return stats, nil
+}
+
+// localPath returns the path in dir that key is fetched to.
+func localPath(dir, prefix, key string) (string, error) {
+	rel := filepath.FromSlash(strings.TrimPrefix(key, prefix+"/"))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("goreach/flush: fetch %s: key escapes the target directory", key)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// selected reports whether o passes the Version filter.
+func (f *Fetcher) selected(prefix string, o Object) bool {
+	if strings.HasSuffix(o.Key, "/") {
+		return false // directory placeholder
+	}
+	if f.Service == "" && f.Version != "" {
+		parts := strings.Split(strings.TrimPrefix(o.Key, prefix+"/"), "/")
+		if len(parts) < 2 || parts[1] != f.Version {
+			return false
+		}
+	}
+	return true
+}
+
+// filterTime applies the Since/Until filter to objects, by marker time in
+// directories with commit markers. It reads the markers in the window to
+// find their files, and returns their contents by key so that Fetch does
+// not download them again.
+func (f *Fetcher) filterTime(ctx context.Context, dir, prefix string, objects []Object) ([]Object, map[string][]byte, error) {
+	markerDirs := make(map[string]bool)
+	listed := make(map[string]bool) // keys of the files of markers in the window
+	markers := make(map[string][]byte)
+	for _, o := range objects {
+		if !isMarkerKey(o.Key) {
+			continue
+		}
+		markerDirs[path.Dir(o.Key)] = true
+		if !f.inWindow(o) {
+			continue
+		}
+		data, err := f.readMarker(ctx, dir, prefix, o)
+		if err != nil {
+			return nil, nil, fmt.Errorf("goreach/flush: fetch %s: %w", o.Key, err)
+		}
+		markers[o.Key] = data
+		var m commitMarker
+		if json.Unmarshal(data, &m) != nil {
+			continue // fetched anyway; covparse reports it
+		}
+		for _, mf := range m.Files {
+			listed[path.Dir(o.Key)+"/"+mf.Name] = true
+			if strings.HasPrefix(mf.Name, "covcounters.") {
+				listed[path.Dir(o.Key)+"/"+flush.ManifestName(mf.Name)] = true
+			}
+		}
+	}
+
+	kept := objects[:0]
+	for _, o := range objects {
+		name := path.Base(o.Key)
+		var keep bool
+		switch {
+		case isMarkerKey(o.Key):
+			_, keep = markers[o.Key]
+		case markerDirs[path.Dir(o.Key)] && (isCoverageFile(name) || flush.IsManifest(name)):
+			keep = listed[o.Key]
+		default:
+			keep = strings.HasPrefix(name, "covmeta.") || f.inWindow(o)
+		}
+		if keep {
+			kept = append(kept, o)
+		}
+	}
+	return kept, markers, nil
+}
+
+// readMarker returns the contents of the commit marker o, from its local
+// copy if that is current.
+func (f *Fetcher) readMarker(ctx context.Context, dir, prefix string, o Object) ([]byte, error) {
+	dst, err := localPath(dir, prefix, o.Key)
+	if err != nil {
+		return nil, err
+	}
+	if upToDate(dst, o) {
+		return os.ReadFile(dst)
+	}
+	body, err := f.Download(ctx, o.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// inWindow reports whether o was last modified in [Since, Until). Objects
+// without a modification time always are.
+func (f *Fetcher) inWindow(o Object) bool {
+	if o.LastModified.IsZero() {
+		return true
+	}
+	if !f.Since.IsZero() && o.LastModified.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || o.LastModified.Before(f.Until)
+}
+
+// fetchObject downloads o to dst via a temporary file, so that readers
+// never see a partial file, and sets its modification time to
+// o.LastModified.
+func (f *Fetcher) fetchObject(ctx context.Context, o Object, dst string) (int64, error) {
+	body, err := f.Download(ctx, o.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return writeObject(body, o, dst)
+}
+
+// writeObject writes the contents of o, read from body, to dst.
+func writeObject(body io.Reader, o Object, dst string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".fetch-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	n, err := io.Copy(tmp, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !o.LastModified.IsZero() {
+		if err := os.Chtimes(tmp.Name(), o.LastModified, o.LastModified); err != nil {
+			return 0, err
+		}
+	}
+	return n, os.Rename(tmp.Name(), dst)
+}
+
+// upToDate reports whether dst is a current copy of o.
+func upToDate(dst string, o Object) bool {
+	info, err := os.Stat(dst)
+	if err != nil || info.Size() != o.Size {
+		return false
+	}
+	return o.LastModified.IsZero() || info.ModTime().Equal(o.LastModified)
+}
+
+// isMarkerKey reports whether key names a commit marker.
+func isMarkerKey(key string) bool {
+	name := path.Base(key)
+	return strings.HasPrefix(name, "goreach-commit.") && strings.HasSuffix(name, ".json")
+}
@@ -0,0 +1,165 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memBucket is an in-memory object store for Fetcher tests.
+type memBucket struct {
+	objects   map[string]Object
+	data      map[string]string
+	downloads []string
+}
+
+func (b *memBucket) put(key, data string, mod time.Time) {
+	if b.objects == nil {
+		b.objects = make(map[string]Object)
+		b.data = make(map[string]string)
+	}
+	b.objects[key] = Object{Key: key, Size: int64(len(data)), LastModified: mod}
+	b.data[key] = data
+}
+
+func (b *memBucket) list(_ context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for key, o := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+func (b *memBucket) download(_ context.Context, key string) (io.ReadCloser, error) {
+	b.downloads = append(b.downloads, key)
+	return io.NopCloser(strings.NewReader(b.data[key])), nil
+}
+
+func TestFetcher(t *testing.T) {
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := old.Add(48 * time.Hour)
+	var b memBucket
+	b.put("goreach/api/v1/pod-0/covmeta.abc", "meta", old)
+	b.put("goreach/api/v1/pod-0/covcounters.abc.1.1", "old", old)
+	b.put("goreach/api/v1/pod-0/covcounters.abc.1.2", "new", recent)
+	marker := `{"files":[{"name":"covmeta.abc"},{"name":"covcounters.abc.1.2"}]}`
+	b.put("goreach/api/v1/pod-0/goreach-commit.2.json", marker, recent)
+	b.put("goreach/api/v2/pod-0/covcounters.abc.1.3", "other version", recent)
+	b.put("goreach/web/v1/pod-0/covcounters.abc.1.4", "other service", recent)
+
+	dir := t.TempDir()
+	f := &Fetcher{List: b.list, Download: b.download, Service: "api", Version: "v1", Since: old.Add(time.Hour)}
+	stats, err := f.Fetch(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"goreach/api/v1/pod-0/goreach-commit.2.json", // read first to select its files
+		"goreach/api/v1/pod-0/covcounters.abc.1.2",
+		"goreach/api/v1/pod-0/covmeta.abc", // listed by the marker
+	}
+	if !slices.Equal(b.downloads, want) {
+		t.Errorf("downloads = %v, want %v", b.downloads, want)
+	}
+	if stats.Downloaded != 3 || stats.Bytes != int64(7+len(marker)) {
+		t.Errorf("stats = %+v", stats)
+	}
+	info, err := os.Stat(filepath.Join(dir, "api/v1/pod-0/covcounters.abc.1.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(recent) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime(), recent)
+	}
+
+	// A second fetch downloads only changed objects.
+	b.downloads = nil
+	b.put("goreach/api/v1/pod-0/goreach-commit.2.json", marker+" ", recent.Add(time.Minute))
+	stats, err = f.Fetch(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(b.downloads, []string{"goreach/api/v1/pod-0/goreach-commit.2.json"}) || stats.UpToDate != 2 {
+		t.Errorf("downloads = %v, stats = %+v; want only the changed object", b.downloads, stats)
+	}
+}
+
+// TestFetcher_MarkerWindow tests that the time filter selects flushes by
+// their commit marker, so that a marker and its files are fetched together.
+func TestFetcher_MarkerWindow(t *testing.T) {
+	since := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	until := since.Add(time.Hour)
+	var b memBucket
+	// Flush 1 straddles Since: its counters were uploaded just before it.
+	b.put("goreach/api/v1/pod-0/covcounters.abc.1.1", "c1", since.Add(-time.Second))
+	b.put("goreach/api/v1/pod-0/goreach-manifest.abc.1.1.json", "{}", since.Add(-time.Second))
+	b.put("goreach/api/v1/pod-0/goreach-commit.1.json", `{"files":[{"name":"covcounters.abc.1.1"}]}`, since.Add(time.Second))
+	// Flush 2 straddles Until: its marker is outside the window.
+	b.put("goreach/api/v1/pod-0/covcounters.abc.1.2", "c2", until.Add(-time.Second))
+	b.put("goreach/api/v1/pod-0/goreach-manifest.abc.1.2.json", "{}", until.Add(-time.Second))
+	b.put("goreach/api/v1/pod-0/goreach-commit.2.json", `{"files":[{"name":"covcounters.abc.1.2"}]}`, until.Add(time.Second))
+	// Directories without markers are filtered per object.
+	b.put("goreach/api/v1/pod-1/covcounters.abc.1.3", "c3", since.Add(time.Minute))
+	b.put("goreach/api/v1/pod-1/covcounters.abc.1.4", "c4", until)
+
+	dir := t.TempDir()
+	f := &Fetcher{List: b.list, Download: b.download, Since: since, Until: until}
+	if _, err := f.Fetch(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for rel, want := range map[string]bool{
+		"api/v1/pod-0/covcounters.abc.1.1":           true,
+		"api/v1/pod-0/goreach-manifest.abc.1.1.json": true,
+		"api/v1/pod-0/goreach-commit.1.json":         true,
+		"api/v1/pod-0/covcounters.abc.1.2":           false,
+		"api/v1/pod-0/goreach-manifest.abc.1.2.json": false,
+		"api/v1/pod-0/goreach-commit.2.json":         false,
+		"api/v1/pod-1/covcounters.abc.1.3":           true,
+		"api/v1/pod-1/covcounters.abc.1.4":           false,
+	} {
+		_, err := os.Stat(filepath.Join(dir, rel))
+		if got := err == nil; got != want {
+			t.Errorf("%s fetched = %v, want %v", rel, got, want)
+		}
+	}
+	if n := strings.Count(strings.Join(b.downloads, " "), "goreach-commit.1.json"); n != 1 {
+		t.Errorf("marker downloaded %d times, want 1", n)
+	}
+}
+
+func TestFetcher_VersionOnly(t *testing.T) {
+	var b memBucket
+	b.put("goreach/api/v1/pod-0/covmeta.abc", "a", time.Time{})
+	b.put("goreach/web/v1/pod-0/covmeta.abc", "b", time.Time{})
+	b.put("goreach/web/v2/pod-0/covmeta.abc", "c", time.Time{})
+
+	f := &Fetcher{List: b.list, Download: b.download, Version: "v1"}
+	if _, err := f.Fetch(context.Background(), t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.downloads) != 2 || slices.Contains(b.downloads, "goreach/web/v2/pod-0/covmeta.abc") {
+		t.Errorf("downloads = %v, want v1 only", b.downloads)
+	}
+}
+
+func TestFetcher_UnsafeKey(t *testing.T) {
+	var b memBucket
+	b.put("goreach/../../etc/covmeta.abc", "x", time.Time{})
+	f := &Fetcher{List: b.list, Download: b.download}
+	_, err := f.Fetch(context.Background(), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "escapes") {
+		t.Errorf("err = %v, want escape error", err)
+	}
+	if len(b.downloads) != 0 {
+		t.Errorf("downloaded %v", b.downloads)
+	}
+}
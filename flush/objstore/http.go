@@ -0,0 +1,201 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPUploader uploads objects with plain HTTP requests, without a cloud
+// SDK. It works with presigned URLs and with S3-compatible endpoints (AWS S3,
+// GCS XML API, MinIO, R2, ...) when combined with a [SigV4] signer:
+//
+//	u := &objstore.HTTPUploader{
+//	    Endpoint: "https://my-bucket.s3.eu-west-1.amazonaws.com",
+//	    Signer:   &objstore.SigV4{AccessKeyID: id, SecretAccessKey: secret, Region: "eu-west-1"},
+//	}
+//	storage := &objstore.Storage{Upload: u.Upload}
+//
+// Bodies are buffered in memory so that failed requests can be retried.
+// HTTPUploader is safe for concurrent use.
+type HTTPUploader struct {
+	// Endpoint is the base URL objects are uploaded to; the key is appended
+	// as the path, e.g. https://host/bucket + /goreach/svc/... Ignored if URL
+	// is set.
+	Endpoint string
+
+	// URL returns the URL to upload key to, e.g. a presigned PUT URL.
+	URL func(ctx context.Context, key string) (string, error)
+
+	Client *http.Client // HTTP client (default http.DefaultClient)
+	Method string       // request method (default PUT)
+	Header http.Header  // extra request headers, e.g. Content-Type or x-amz-storage-class
+
+	// Signer signs each request with AWS Signature Version 4. Nil sends
+	// requests unsigned, as needed for presigned URLs.
+	Signer *SigV4
+
+	MaxAttempts int           // attempts per object (default 3)
+	BaseDelay   time.Duration // delay before the first retry (default 500ms), doubled per attempt
+	MaxDelay    time.Duration // upper bound for the retry delay (default 10s)
+}
+
+// Upload uploads body to key. It implements [Uploader].
+//
+// Network errors, 429 and 5xx responses are retried with exponential
+// backoff and jitter. Other non-2xx responses fail immediately.
+func (u *HTTPUploader) Upload(ctx context.Context, key string, body io.Reader) error {
+	target, err := u.target(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", key, err)
+	}
+
+	attempts := u.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	for attempt := range attempts {
+		if attempt > 0 {
+			t := time.NewTimer(u.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return fmt.Errorf("%w (after %d attempts: %w)", ctx.Err(), attempt, err)
+			case <-t.C:
+			}
+		}
+		var retry bool
+		if retry, err = u.put(ctx, target, data); err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("%d attempts failed: %w", attempts, err)
+}
+
+// target returns the upload URL for key.
+func (u *HTTPUploader) target(ctx context.Context, key string) (*url.URL, error) {
+	raw := strings.TrimSuffix(u.Endpoint, "/") + "/" + escapePath(key)
+	if u.URL != nil {
+		var err error
+		if raw, err = u.URL(ctx, key); err != nil {
+			return nil, fmt.Errorf("url for %s: %w", key, err)
+		}
+	} else if u.Endpoint == "" {
+		return nil, errors.New("HTTPUploader: Endpoint or URL is required")
+	}
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("url for %s: %w", key, redact(err))
+	}
+	return target, nil
+}
+
+// put sends one request. It reports whether a failure is worth retrying.
+func (u *HTTPUploader) put(ctx context.Context, target *url.URL, data []byte) (retry bool, err error) {
+	method := u.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+	resp, retry, err := send(ctx, u.Client, u.Signer, u.Header, method, target, data)
+	if err != nil {
+		return retry, err
+	}
+	resp.Body.Close()
+	return false, nil
+}
+
+// send sends a request with body data (nil for none), signed by signer if
+// non-nil. Non-2xx responses are returned as errors; the retry result
+// reports whether the failure is worth retrying. On success the caller
+// closes the response body.
+func send(ctx context.Context, client *http.Client, signer *SigV4, header http.Header, method string, target *url.URL, data []byte) (resp *http.Response, retry bool, err error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, false, redact(err)
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if signer != nil {
+		sum := sha256.Sum256(data)
+		signer.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, redact(err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, false, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s %s: %s", method, stripQuery(target), resp.Status)
+	if msg := strings.TrimSpace(string(msg)); msg != "" {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+	return nil, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// backoff returns the delay before the given retry (1-based), using
+// exponential growth with "equal jitter": half fixed, half random.
+func (u *HTTPUploader) backoff(retry int) time.Duration {
+	base := u.BaseDelay
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	maxDelay := u.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+	d := base
+	for i := 1; i < retry && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// redact removes the query, which carries the credentials of presigned
+// URLs, from the URL in err.
+func redact(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		if u, perr := url.Parse(ue.URL); perr == nil {
+			ue.URL = stripQuery(u)
+		} else {
+			ue.URL = "<invalid URL>"
+		}
+	}
+	return err
+}
+
+func stripQuery(u *url.URL) string {
+	v := *u
+	v.RawQuery = ""
+	v.Fragment = ""
+	v.User = nil
+	return v.String()
+}
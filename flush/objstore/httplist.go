@@ -0,0 +1,96 @@
+package objstore
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPDownloader is the read-side counterpart of [HTTPUploader]: it lists
+// objects with the S3 ListObjectsV2 API and downloads them with GET, which
+// S3-compatible stores (AWS S3, GCS XML API, MinIO, R2, ...) support.
+//
+//	d := &objstore.HTTPDownloader{Endpoint: "https://my-bucket.s3.eu-west-1.amazonaws.com", Signer: signer}
+//	f := &objstore.Fetcher{List: d.List, Download: d.Download, Service: "api"}
+type HTTPDownloader struct {
+	// Endpoint is the bucket URL, e.g. https://bucket.s3.region.amazonaws.com
+	// or https://host/bucket for path-style endpoints.
+	Endpoint string
+
+	Client *http.Client // HTTP client (default http.DefaultClient)
+	Header http.Header  // extra request headers
+
+	// Signer signs each request with AWS Signature Version 4. Nil sends
+	// requests unsigned, e.g. for public buckets.
+	Signer *SigV4
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response used here.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns all objects whose keys start with prefix, following
+// pagination. It implements [Lister].
+func (d *HTTPDownloader) List(ctx context.Context, prefix string) ([]Object, error) {
+	if d.Endpoint == "" {
+		return nil, errors.New("HTTPDownloader: Endpoint is required")
+	}
+	var objects []Object
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		target, err := url.Parse(strings.TrimSuffix(d.Endpoint, "/") + "/?" + q.Encode())
+		if err != nil {
+			return nil, err
+		}
+		resp, _, err := send(ctx, d.Client, d.Signer, d.Header, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode list response: %w", err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, LastModified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Download returns the contents of key. It implements [Downloader].
+func (d *HTTPDownloader) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	if d.Endpoint == "" {
+		return nil, errors.New("HTTPDownloader: Endpoint is required")
+	}
+	target, err := url.Parse(strings.TrimSuffix(d.Endpoint, "/") + "/" + escapePath(key))
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := send(ctx, d.Client, d.Signer, d.Header, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
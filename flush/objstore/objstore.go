@@ -2,15 +2,21 @@
 // coverage files to a remote object store (S3, GCS, Azure Blob, etc.).
 //
 // The caller provides an [Uploader] function that performs the actual upload,
-// keeping cloud SDK dependencies out of this module.
+// keeping cloud SDK dependencies out of this module. [HTTPUploader] is a
+// dependency-free Uploader for presigned URLs and S3-compatible endpoints.
 package objstore
 
 import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/yag13s/goreach/flush"
 )
@@ -23,53 +29,296 @@ type Uploader func(ctx context.Context, key string, body io.Reader) error
 // KeyFunc generates an object key for a given file and metadata.
 type KeyFunc func(prefix string, meta flush.Metadata, filename string) string
 
+// ExistsFunc reports whether an object with the given key exists.
+type ExistsFunc func(ctx context.Context, key string) (bool, error)
+
 // Storage uploads coverage files using the provided [Uploader].
+//
+// covmeta files are identical for the lifetime of a build, so Storage
+// uploads each covmeta key only once and sends only covcounters (and the
+// manifest) on subsequent flushes.
 type Storage struct {
 	Upload  Uploader
 	Prefix  string  // key prefix (default "goreach")
-	KeyFunc KeyFunc // custom key generator (nil uses defaultKey)
+	KeyFunc KeyFunc // custom key generator (nil uses KeyTemplate or defaultKey)
+
+	// KeyTemplate sets the object key layout without code, e.g.
+	// "{prefix}/{service}/{date:2006/01/02}/{version}/{pod}/{file}".
+	// See Validate for the placeholders. Ignored if KeyFunc is set.
+	KeyTemplate string
+
+	// Exists, if set, is asked before uploading a covmeta key this Storage
+	// has not delivered yet, e.g. after a restart or when pods share keys.
+	// Existing keys are not uploaded. Errors are ignored and the file is
+	// uploaded.
+	Exists ExistsFunc
+
+	// CommitMarker uploads a marker object, goreach-commit.<unix-nanos>.json,
+	// after all files of a flush were uploaded. It lists the covmeta and
+	// covcounters files with their sizes and SHA-256 checksums, so that
+	// goreach analyze can skip flushes that were interrupted midway.
+	// Bundles are single objects and need no marker.
+	CommitMarker bool
+
+	// Concurrency is the maximum number of files of one flush uploaded in
+	// parallel (default 1, sequential). Upload and Exists must be safe for
+	// concurrent use if it is greater than 1. The first failed upload
+	// cancels the context of the others and is returned.
+	Concurrency int
+
+	// Bundle uploads each flush as a single gzip-compressed tar object,
+	// covbundle.<unix-nanos>.tar.gz, containing the covmeta, covcounters and
+	// manifest files. This trades the covmeta deduplication above for one
+	// request per flush. goreach analyze expands bundles transparently.
+	Bundle bool
+
+	mu       sync.Mutex
+	metaKeys map[string]string // covmeta file name -> last key known to be in the store
 }
 
 // compile-time check
-var _ flush.Storage = (*Storage)(nil)
+var (
+	_ flush.StreamStorage = (*Storage)(nil)
+	_ flush.Validator     = (*Storage)(nil)
+)
+
+// Validate checks the configuration: Upload must be set, and KeyTemplate
+// must be well-formed. flush.New calls it when the Flusher is created.
+//
+// Key template placeholders:
+//
+//	{prefix}               Prefix
+//	{service}              Metadata.ServiceName
+//	{version}              Metadata.BuildVersion
+//	{pod}                  Metadata.PodName
+//	{host}                 Metadata.Hostname
+//	{file}                 file name (required)
+//	{date:<layout>}        Metadata.Timestamp in UTC, formatted with a Go time layout (default 2006-01-02)
+//	{unix}                 Metadata.Timestamp as Unix seconds
+//	{build_date:<layout>}  Metadata.BuildTime in UTC, like {date}
+//	{label:<name>}         value of label <name>
+//	{labels}               all labels as <name>=<value> segments, sorted by name
+//
+// Values taken from Metadata and the file name are path-escaped, so that a
+// value containing "/" stays a single key segment; {prefix} and the time
+// layouts are used as given. Empty values are rendered as "unknown".
+func (s *Storage) Validate() error {
+	if s.Upload == nil {
+		return fmt.Errorf("goreach/flush: objstore: Upload is nil")
+	}
+	_, err := s.keyFunc()
+	return err
+}
 
 // Store uploads each file in files via the configured [Uploader].
 func (s *Storage) Store(ctx context.Context, files []string, meta flush.Metadata) error {
 	if s.Upload == nil {
 		return fmt.Errorf("goreach/flush: objstore: Upload is nil")
 	}
+	streams, closeAll, err := openFiles(files)
+	if err != nil {
+		return err
+	}
+	err = s.StoreStreams(ctx, streams, meta)
+	if closeErr := closeAll(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// StoreStreams uploads each stream in files via the configured [Uploader],
+// without touching the local filesystem.
+func (s *Storage) StoreStreams(ctx context.Context, files []flush.StreamFile, meta flush.Metadata) error {
+	if s.Upload == nil {
+		return fmt.Errorf("goreach/flush: objstore: Upload is nil")
+	}
+	keyFn, err := s.keyFunc()
+	if err != nil {
+		return err
+	}
+	if s.Bundle {
+		return s.storeBundle(ctx, files, meta, keyFn)
+	}
+
+	n := max(s.Concurrency, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sem      = make(chan struct{}, n)
+		entries  = make([]*markerFile, len(files))
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	for i, f := range files {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// Canceled by a failed upload or the caller; start no more.
+			fail(fmt.Errorf("goreach/flush: upload %s: %w", f.Name, ctx.Err()))
+			break
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			entry, err := s.storeFile(ctx, f, keyFn(meta, f.Name))
+			if err != nil {
+				fail(err)
+				return
+			}
+			entries[i] = entry
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
 
+	if s.CommitMarker {
+		var marker commitMarker
+		for _, e := range entries {
+			if e != nil {
+				marker.Files = append(marker.Files, *e)
+			}
+		}
+		return s.storeMarker(ctx, marker, meta, keyFn)
+	}
+	return nil
+}
+
+// storeFile uploads f to key, unless it is a covmeta file already in the
+// store. It returns the file's commit marker entry if CommitMarker is set
+// and f is a coverage file.
+func (s *Storage) storeFile(ctx context.Context, f flush.StreamFile, key string) (*markerFile, error) {
+	isMeta := strings.HasPrefix(f.Name, "covmeta.")
+
+	body := f.Body
+	var d *digest
+	if s.CommitMarker && isCoverageFile(f.Name) {
+		d = newDigest()
+		body = io.TeeReader(body, d)
+	}
+
+	if isMeta && s.metaStored(ctx, f.Name, key) {
+		// Already in the store; still checksummed for the marker.
+		if d != nil {
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return nil, fmt.Errorf("goreach/flush: read %s: %w", f.Name, err)
+			}
+		}
+	} else {
+		if err := s.Upload(ctx, key, body); err != nil {
+			return nil, fmt.Errorf("goreach/flush: upload %s: %w", f.Name, err)
+		}
+		if isMeta {
+			s.markMetaStored(f.Name, key)
+		}
+	}
+	if d == nil {
+		return nil, nil
+	}
+	entry := d.file(f.Name)
+	return &entry, nil
+}
+
+// openFiles opens paths as StreamFiles. The returned function closes them
+// and returns the first error.
+func openFiles(paths []string) ([]flush.StreamFile, func() error, error) {
+	var opened []*os.File
+	closeAll := func() error {
+		var first error
+		for _, f := range opened {
+			if err := f.Close(); err != nil && first == nil {
+				first = fmt.Errorf("goreach/flush: close %s: %w", filepath.Base(f.Name()), err)
+			}
+		}
+		return first
+	}
+	files := make([]flush.StreamFile, 0, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("goreach/flush: open %s: %w", filepath.Base(p), err)
+		}
+		opened = append(opened, f)
+		info, err := f.Stat()
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("goreach/flush: stat %s: %w", filepath.Base(p), err)
+		}
+		files = append(files, flush.StreamFile{Name: filepath.Base(p), Size: info.Size(), Body: f})
+	}
+	return files, closeAll, nil
+}
+
+// keyFunc returns the function producing object keys for a flush, bound to
+// the configured prefix.
+func (s *Storage) keyFunc() (func(meta flush.Metadata, filename string) string, error) {
 	prefix := s.Prefix
 	if prefix == "" {
 		prefix = "goreach"
 	}
 	keyFn := s.KeyFunc
+	if keyFn == nil && s.KeyTemplate != "" {
+		t, err := parseKeyTemplate(s.KeyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		keyFn = t.execute
+	}
 	if keyFn == nil {
 		keyFn = defaultKey
 	}
+	return func(meta flush.Metadata, filename string) string {
+		return keyFn(prefix, meta, filename)
+	}, nil
+}
 
-	for _, f := range files {
-		body, err := os.Open(f)
-		if err != nil {
-			return fmt.Errorf("goreach/flush: open %s: %w", filepath.Base(f), err)
-		}
-
-		key := keyFn(prefix, meta, filepath.Base(f))
-		uploadErr := s.Upload(ctx, key, body)
-		closeErr := body.Close()
+// metaStored reports whether the covmeta file name is already in the store
+// under key, consulting Exists for keys not delivered by this Storage.
+func (s *Storage) metaStored(ctx context.Context, name, key string) bool {
+	s.mu.Lock()
+	known := s.metaKeys[name] == key
+	s.mu.Unlock()
+	if known || s.Exists == nil {
+		return known
+	}
+	if ok, err := s.Exists(ctx, key); err == nil && ok {
+		s.markMetaStored(name, key)
+		return true
+	}
+	return false
+}
 
-		if uploadErr != nil {
-			return fmt.Errorf("goreach/flush: upload %s: %w", filepath.Base(f), uploadErr)
-		}
-		if closeErr != nil {
-			return fmt.Errorf("goreach/flush: close %s: %w", filepath.Base(f), closeErr)
-		}
+// markMetaStored records key as the stored object of the covmeta file name.
+// Only the last key per file name is kept, so key templates with {unix} or
+// {date} do not grow the map with every flush.
+func (s *Storage) markMetaStored(name, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metaKeys == nil {
+		s.metaKeys = make(map[string]string)
 	}
-	return nil
+	s.metaKeys[name] = key
 }
 
 // defaultKey produces keys in the form: <prefix>/<service>/<version>/<pod>/<filename>.
+// Labels, if any, are inserted before <pod> as one <name>=<value> segment
+// each, sorted by name.
 func defaultKey(prefix string, meta flush.Metadata, filename string) string {
+	version := meta.BuildVersion
+	for _, k := range slices.Sorted(maps.Keys(meta.Labels)) {
+		version += "/" + url.PathEscape(k) + "=" + url.PathEscape(meta.Labels[k])
+	}
 	return fmt.Sprintf("%s/%s/%s/%s/%s",
-		prefix, meta.ServiceName, meta.BuildVersion, meta.PodName, filename)
+		prefix, meta.ServiceName, version, meta.PodName, filename)
 }
@@ -1,13 +1,25 @@
 package objstore
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/yag13s/goreach/flush"
 )
@@ -204,6 +216,29 @@ func TestStorage_Store_UploadError(t *testing.T) {
 	}
 }
 
+// TestStorage_Store_CloseError tests that Store reports a file that fails
+// to close after a successful upload.
+func TestStorage_Store_CloseError(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "covmeta.abc"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the file during the upload makes the final Close fail.
+	upload := func(_ context.Context, _ string, body io.Reader) error {
+		c, ok := body.(io.Closer)
+		if !ok {
+			t.Fatalf("body is %T, want the opened file", body)
+		}
+		return c.Close()
+	}
+	storage := &Storage{Upload: upload}
+	err := storage.Store(context.Background(), []string{filepath.Join(srcDir, "covmeta.abc")}, flush.Metadata{})
+	if err == nil || !strings.Contains(err.Error(), "goreach/flush: close covmeta.abc") {
+		t.Errorf("err = %v, want close error", err)
+	}
+}
+
 func TestDefaultKey(t *testing.T) {
 	meta := flush.Metadata{
 		ServiceName:  "my-svc",
@@ -217,3 +252,323 @@ func TestDefaultKey(t *testing.T) {
 		t.Errorf("defaultKey() = %q, want %q", got, want)
 	}
 }
+
+func TestDefaultKey_Labels(t *testing.T) {
+	meta := flush.Metadata{
+		ServiceName:  "my-svc",
+		BuildVersion: "v2.0.1",
+		PodName:      "my-svc-abc-xyz",
+		Labels:       map[string]string{"region": "eu-west-1", "cohort": "canary/a"},
+	}
+
+	got := defaultKey("goreach", meta, "covmeta.12345")
+	want := "goreach/my-svc/v2.0.1/cohort=canary%2Fa/region=eu-west-1/my-svc-abc-xyz/covmeta.12345"
+	if got != want {
+		t.Errorf("defaultKey() = %q, want %q", got, want)
+	}
+}
+
+// TestStorage_Store_SkipsDeliveredMeta tests that a covmeta key is uploaded
+// only once, while covcounters are uploaded on every flush.
+func TestStorage_Store_SkipsDeliveredMeta(t *testing.T) {
+	srcDir := t.TempDir()
+	files := []string{filepath.Join(srcDir, "covmeta.abc"), filepath.Join(srcDir, "covcounters.abc")}
+	for _, f := range files {
+		if err := os.WriteFile(f, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls []uploadCall
+	storage := &Storage{Upload: mockUploader(&calls, nil)}
+	meta := flush.Metadata{ServiceName: "svc", BuildVersion: "v1", PodName: "pod-0"}
+	for range 3 {
+		if err := storage.Store(context.Background(), files, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var metaUploads, counterUploads int
+	for _, c := range calls {
+		switch filepath.Base(c.Key) {
+		case "covmeta.abc":
+			metaUploads++
+		case "covcounters.abc":
+			counterUploads++
+		}
+	}
+	if metaUploads != 1 || counterUploads != 3 {
+		t.Errorf("covmeta uploads = %d, covcounters uploads = %d; want 1 and 3", metaUploads, counterUploads)
+	}
+
+	// A different key (another pod) is uploaded again.
+	meta.PodName = "pod-1"
+	if err := storage.Store(context.Background(), files[:1], meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 5 {
+		t.Errorf("Upload called %d times, want 5", len(calls))
+	}
+}
+
+// TestStorage_Store_MetaKeysBounded tests that keys changing with every
+// flush, as with {unix} in a KeyTemplate, do not accumulate in the covmeta
+// dedupe map.
+func TestStorage_Store_MetaKeysBounded(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "covmeta.abc")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []uploadCall
+	storage := &Storage{Upload: mockUploader(&calls, nil), KeyTemplate: "{unix}/{file}"}
+	for i := range 5 {
+		meta := flush.Metadata{Timestamp: time.Unix(int64(i), 0)}
+		if err := storage.Store(context.Background(), []string{file}, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(calls) != 5 {
+		t.Errorf("Upload called %d times, want 5", len(calls))
+	}
+	if len(storage.metaKeys) != 1 {
+		t.Errorf("metaKeys has %d entries, want 1", len(storage.metaKeys))
+	}
+}
+
+func TestStorage_Store_Exists(t *testing.T) {
+	srcDir := t.TempDir()
+	file := filepath.Join(srcDir, "covmeta.abc")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []uploadCall
+	var asked []string
+	storage := &Storage{
+		Upload: mockUploader(&calls, nil),
+		Exists: func(_ context.Context, key string) (bool, error) {
+			asked = append(asked, key)
+			if strings.Contains(key, "pod-err") {
+				return false, fmt.Errorf("head failed")
+			}
+			return strings.Contains(key, "pod-0"), nil
+		},
+	}
+
+	for _, pod := range []string{"pod-0", "pod-0", "pod-err"} {
+		if err := storage.Store(context.Background(), []string{file}, flush.Metadata{PodName: pod}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(calls) != 1 || !strings.Contains(calls[0].Key, "pod-err") {
+		t.Errorf("uploads = %v, want only pod-err", calls)
+	}
+	if len(asked) != 2 {
+		t.Errorf("Exists called %d times, want 2 (cached after first hit)", len(asked))
+	}
+}
+
+func TestStorage_StoreStreams(t *testing.T) {
+	var calls []uploadCall
+	storage := &Storage{Upload: mockUploader(&calls, nil)}
+	meta := flush.Metadata{ServiceName: "svc", BuildVersion: "v1", PodName: "pod-0"}
+
+	for range 2 {
+		files := []flush.StreamFile{
+			{Name: "covmeta.abc", Body: strings.NewReader("meta")},
+			{Name: "covcounters.abc.1.2", Body: strings.NewReader("counters")},
+		}
+		if err := storage.StoreStreams(context.Background(), files, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(calls) != 3 {
+		t.Fatalf("Upload called %d times, want 3 (covmeta once)", len(calls))
+	}
+	if calls[0].Key != "goreach/svc/v1/pod-0/covmeta.abc" || string(calls[0].Body) != "meta" {
+		t.Errorf("calls[0] = %s %q", calls[0].Key, calls[0].Body)
+	}
+	if calls[2].Key != "goreach/svc/v1/pod-0/covcounters.abc.1.2" || string(calls[2].Body) != "counters" {
+		t.Errorf("calls[2] = %s %q", calls[2].Key, calls[2].Body)
+	}
+}
+
+func TestStorage_Store_Bundle(t *testing.T) {
+	srcDir := t.TempDir()
+	contents := map[string]string{"covmeta.abc": "meta", "covcounters.abc.1.2": "counters", flush.ManifestName("covcounters.abc.1.2"): "{}"}
+	var files []string
+	for name, data := range contents {
+		f := filepath.Join(srcDir, name)
+		if err := os.WriteFile(f, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	var calls []uploadCall
+	storage := &Storage{Upload: mockUploader(&calls, nil), Bundle: true}
+	meta := flush.Metadata{ServiceName: "svc", BuildVersion: "v1", PodName: "pod-0", Timestamp: time.Unix(0, 42)}
+	for range 2 {
+		if err := storage.Store(context.Background(), files, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("Upload called %d times, want one per flush", len(calls))
+	}
+	if want := "goreach/svc/v1/pod-0/covbundle.42.tar.gz"; calls[0].Key != want {
+		t.Errorf("Key = %q, want %q", calls[0].Key, want)
+	}
+	// Bundles are self-contained, so the second one includes covmeta too.
+	for _, c := range calls {
+		zr, err := gzip.NewReader(bytes.NewReader(c.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(zr)
+		got := make(map[string]string)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(tr)
+			got[hdr.Name] = string(data)
+		}
+		if !maps.Equal(got, contents) {
+			t.Errorf("bundle contents = %v, want %v", got, contents)
+		}
+	}
+}
+
+// TestStorage_CommitMarker tests that a commit marker listing every coverage
+// file of the flush, including already delivered covmeta, is uploaded last.
+func TestStorage_CommitMarker(t *testing.T) {
+	var calls []uploadCall
+	storage := &Storage{Upload: mockUploader(&calls, nil), CommitMarker: true}
+
+	for i := range 2 {
+		files := []flush.StreamFile{
+			{Name: "covmeta.abc", Body: strings.NewReader("meta")},
+			{Name: "covcounters.abc.1.2", Body: strings.NewReader("counters")},
+			{Name: flush.ManifestName("covcounters.abc.1.2"), Body: strings.NewReader("{}")},
+		}
+		meta := flush.Metadata{ServiceName: "svc", BuildVersion: "v1", PodName: "pod-0", Timestamp: time.Unix(0, int64(i+1))}
+		if err := storage.StoreStreams(context.Background(), files, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flush 1: covmeta, covcounters, manifest, marker. Flush 2 skips covmeta.
+	if len(calls) != 7 {
+		t.Fatalf("Upload called %d times, want 7", len(calls))
+	}
+	last := calls[len(calls)-1]
+	if want := "goreach/svc/v1/pod-0/goreach-commit.2.json"; last.Key != want {
+		t.Fatalf("last Key = %q, want %q", last.Key, want)
+	}
+	var marker commitMarker
+	if err := json.Unmarshal(last.Body, &marker); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("meta"))
+	want := []markerFile{
+		{Name: "covmeta.abc", Size: 4, SHA256: hex.EncodeToString(sum[:])},
+		{Name: "covcounters.abc.1.2", Size: 8},
+	}
+	sum = sha256.Sum256([]byte("counters"))
+	want[1].SHA256 = hex.EncodeToString(sum[:])
+	if !slices.Equal(marker.Files, want) {
+		t.Errorf("marker files = %+v, want %+v", marker.Files, want)
+	}
+}
+
+func TestStorage_CommitMarker_Bundle(t *testing.T) {
+	var calls []uploadCall
+	storage := &Storage{Upload: mockUploader(&calls, nil), CommitMarker: true, Bundle: true}
+	files := []flush.StreamFile{{Name: "covmeta.abc", Size: 4, Body: strings.NewReader("meta")}}
+	if err := storage.StoreStreams(context.Background(), files, flush.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || strings.Contains(calls[0].Key, "goreach-commit") {
+		t.Errorf("uploads = %v, want only the bundle", calls)
+	}
+}
+
+// TestStorage_Concurrency tests that up to Concurrency files are uploaded at
+// the same time.
+func TestStorage_Concurrency(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+		keys     []string
+	)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	storage := &Storage{
+		Concurrency: 3,
+		Upload: func(_ context.Context, key string, body io.Reader) error {
+			mu.Lock()
+			inFlight++
+			peak = max(peak, inFlight)
+			keys = append(keys, key)
+			if inFlight == 3 {
+				releaseOnce.Do(func() { close(release) })
+			}
+			mu.Unlock()
+			<-release
+			_, err := io.Copy(io.Discard, body)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return err
+		},
+	}
+
+	var files []flush.StreamFile
+	for i := range 5 {
+		files = append(files, flush.StreamFile{Name: fmt.Sprintf("covcounters.abc.%d.1", i), Body: strings.NewReader("x")})
+	}
+	if err := storage.StoreStreams(context.Background(), files, flush.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if peak != 3 || len(keys) != 5 {
+		t.Errorf("peak in-flight uploads = %d, uploads = %d; want 3 and 5", peak, len(keys))
+	}
+}
+
+// TestStorage_Concurrency_FirstError tests that the first failed upload
+// cancels the others, stops further uploads and is returned.
+func TestStorage_Concurrency_FirstError(t *testing.T) {
+	var started atomic.Int32
+	storage := &Storage{
+		Concurrency: 2,
+		Upload: func(ctx context.Context, key string, _ io.Reader) error {
+			started.Add(1)
+			if strings.HasSuffix(key, ".0.1") {
+				return errors.New("boom")
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	var files []flush.StreamFile
+	for i := range 10 {
+		files = append(files, flush.StreamFile{Name: fmt.Sprintf("covcounters.abc.%d.1", i), Body: strings.NewReader("x")})
+	}
+	err := storage.StoreStreams(context.Background(), files, flush.Metadata{})
+	if err == nil || err.Error() != "goreach/flush: upload covcounters.abc.0.1: boom" {
+		t.Errorf("err = %v, want the first upload error", err)
+	}
+	if n := started.Load(); n > 3 {
+		t.Errorf("%d uploads started after the failure, want at most 3", n)
+	}
+}
@@ -0,0 +1,120 @@
+package objstore
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+// keyTemplate is a parsed Storage.KeyTemplate: literal text and
+// placeholders in braces. The placeholders are listed on Storage.Validate.
+type keyTemplate []keyPart
+
+// keyPart is a literal (name == "") or a placeholder with optional argument.
+type keyPart struct {
+	lit       string
+	name, arg string
+}
+
+// parseKeyTemplate parses and validates a key template.
+func parseKeyTemplate(s string) (keyTemplate, error) {
+	var t keyTemplate
+	hasFile := false
+	for rest := s; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t = append(t, keyPart{lit: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("goreach/flush: objstore: key template %q: unexpected '}'", s)
+		}
+		if open > 0 {
+			t = append(t, keyPart{lit: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("goreach/flush: objstore: key template %q: unclosed '{'", s)
+		}
+		name, arg, hasArg := strings.Cut(rest[open+1:open+end], ":")
+		switch name {
+		case "prefix", "service", "version", "pod", "host", "file", "unix", "labels":
+			if hasArg {
+				return nil, fmt.Errorf("goreach/flush: objstore: key template %q: {%s} takes no argument", s, name)
+			}
+		case "date", "build_date":
+			if arg == "" {
+				arg = "2006-01-02"
+			}
+		case "label":
+			if arg == "" {
+				return nil, fmt.Errorf("goreach/flush: objstore: key template %q: {label} needs a name, e.g. {label:region}", s)
+			}
+		default:
+			return nil, fmt.Errorf("goreach/flush: objstore: key template %q: unknown placeholder {%s}", s, name)
+		}
+		hasFile = hasFile || name == "file"
+		t = append(t, keyPart{name: name, arg: arg})
+		rest = rest[open+end+1:]
+	}
+	if !hasFile {
+		return nil, fmt.Errorf("goreach/flush: objstore: key template %q: missing {file}", s)
+	}
+	return t, nil
+}
+
+// execute renders the key for filename. Metadata values are path-escaped
+// so that each stays a single key segment.
+func (t keyTemplate) execute(prefix string, meta flush.Metadata, filename string) string {
+	var sb strings.Builder
+	for _, p := range t {
+		var v string
+		switch p.name {
+		case "":
+			sb.WriteString(p.lit)
+			continue
+		case "prefix":
+			v = prefix
+		case "service":
+			v = url.PathEscape(meta.ServiceName)
+		case "version":
+			v = url.PathEscape(meta.BuildVersion)
+		case "pod":
+			v = url.PathEscape(meta.PodName)
+		case "host":
+			v = url.PathEscape(meta.Hostname)
+		case "file":
+			v = url.PathEscape(filename)
+		case "unix":
+			if !meta.Timestamp.IsZero() {
+				v = strconv.FormatInt(meta.Timestamp.Unix(), 10)
+			}
+		case "date":
+			if !meta.Timestamp.IsZero() {
+				v = meta.Timestamp.UTC().Format(p.arg)
+			}
+		case "build_date":
+			if !meta.BuildTime.IsZero() {
+				v = meta.BuildTime.UTC().Format(p.arg)
+			}
+		case "label":
+			v = url.PathEscape(meta.Labels[p.arg])
+		case "labels":
+			segs := make([]string, 0, len(meta.Labels))
+			for _, k := range slices.Sorted(maps.Keys(meta.Labels)) {
+				segs = append(segs, url.PathEscape(k)+"="+url.PathEscape(meta.Labels[k]))
+			}
+			v = strings.Join(segs, "/")
+		}
+		if v == "" {
+			v = "unknown"
+		}
+		sb.WriteString(v)
+	}
+	return sb.String()
+}
@@ -0,0 +1,109 @@
+package objstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yag13s/goreach/flush"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	meta := flush.Metadata{
+		Timestamp:    time.Date(2026, 3, 4, 23, 30, 0, 0, time.FixedZone("JST", 9*3600)),
+		Hostname:     "node-1",
+		PodName:      "pod-0",
+		BuildVersion: "v1",
+		BuildTime:    time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		ServiceName:  "svc",
+		Labels:       map[string]string{"region": "eu", "cohort": "canary"},
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{prefix}/{service}/{date:2006/01/02}/{version}/{pod}/{file}", "goreach/svc/2026/03/04/v1/pod-0/covmeta.abc"},
+		{"{service}/{date}/{host}/{unix}-{file}", "svc/2026-03-04/node-1/1772634600-covmeta.abc"},
+		{"{service}/{label:region}/{label:tenant}/{file}", "svc/eu/unknown/covmeta.abc"},
+		{"{labels}/{build_date:200601}/{file}", "cohort=canary/region=eu/202602/covmeta.abc"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseKeyTemplate(tt.tmpl)
+		if err != nil {
+			t.Errorf("parseKeyTemplate(%q): %v", tt.tmpl, err)
+			continue
+		}
+		if got := tmpl.execute("goreach", meta, "covmeta.abc"); got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+// TestKeyTemplate_Escape tests that metadata values containing "/" or ".."
+// cannot add key segments.
+func TestKeyTemplate_Escape(t *testing.T) {
+	meta := flush.Metadata{
+		ServiceName:  "team/svc",
+		BuildVersion: "../v1",
+		PodName:      "ns/pod-0",
+		Hostname:     "a/b",
+		Labels:       map[string]string{"region": "eu/west"},
+	}
+	tmpl, err := parseKeyTemplate("{prefix}/{service}/{version}/{label:region}/{labels}/{host}/{pod}/{file}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tmpl.execute("goreach/cov", meta, "covmeta.abc")
+	want := "goreach/cov/team%2Fsvc/..%2Fv1/eu%2Fwest/region=eu%2Fwest/a%2Fb/ns%2Fpod-0/covmeta.abc"
+	if got != want {
+		t.Errorf("key = %q, want %q", got, want)
+	}
+}
+
+func TestKeyTemplate_Invalid(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{service}/{pod}", "missing {file}"},
+		{"{service}/{nope}/{file}", "unknown placeholder {nope}"},
+		{"{service/{file}", "unknown placeholder"},
+		{"{service}/{file", "unclosed"},
+		{"{service}}/{file}", "unexpected '}'"},
+		{"{label}/{file}", "needs a name"},
+		{"{pod:x}/{file}", "takes no argument"},
+	}
+	for _, tt := range tests {
+		_, err := parseKeyTemplate(tt.tmpl)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseKeyTemplate(%q) = %v, want error containing %q", tt.tmpl, err, tt.want)
+		}
+	}
+}
+
+// TestStorage_KeyTemplate tests that Validate reports bad templates and that
+// Store uses the template.
+func TestStorage_KeyTemplate(t *testing.T) {
+	var calls []uploadCall
+	s := &Storage{Upload: mockUploader(&calls, nil), KeyTemplate: "{service}/{bogus}/{file}"}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate should reject unknown placeholder")
+	}
+
+	s.KeyTemplate = "{prefix}/{date:2006}/{service}/{file}"
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	files := []flush.StreamFile{{Name: "covcounters.abc", Body: strings.NewReader("c")}}
+	meta := flush.Metadata{ServiceName: "svc", Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := s.StoreStreams(t.Context(), files, meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0].Key != "goreach/2026/svc/covcounters.abc" {
+		t.Errorf("calls = %v", calls)
+	}
+
+	if err := (&Storage{}).Validate(); err == nil {
+		t.Error("Validate should reject nil Upload")
+	}
+}
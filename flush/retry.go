@@ -0,0 +1,309 @@
+package flush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetryStorage wraps a Storage with retries and a local spool, so that a
+// transient backend outage does not lose a flush interval's counters.
+//
+// Each Store call is retried with exponential backoff and jitter. If all
+// attempts fail and SpoolDir is set, the files are copied into the spool and
+// delivered before the next flush. Store still returns an error in that case
+// so the failure shows up in Stats and OnError, but the error matches
+// ErrSpooled and the flush counts as taken over by the spool: with
+// Config.Clear the counters are still cleared, so the next flush does not
+// repeat them.
+type RetryStorage struct {
+	Storage Storage // destination to deliver to (required)
+
+	MaxAttempts int           // attempts per delivery (default 3)
+	BaseDelay   time.Duration // delay before the first retry (default 1s), doubled per attempt
+	MaxDelay    time.Duration // upper bound for the retry delay (default 30s)
+
+	// SpoolDir holds flushes that could not be delivered. Empty disables spooling.
+	SpoolDir string
+
+	// SpoolMaxBytes bounds the total size of the spool (default 64 MiB).
+	// The oldest spooled flushes are dropped first.
+	SpoolMaxBytes int64
+
+	mu sync.Mutex // serializes deliveries and spool access
+}
+
+// compile-time check
+var (
+	_ Storage   = (*RetryStorage)(nil)
+	_ Validator = (*RetryStorage)(nil)
+)
+
+// Validate checks that Storage is set and validates it.
+func (s *RetryStorage) Validate() error {
+	if s.Storage == nil {
+		return fmt.Errorf("goreach/flush: retry: Storage is nil")
+	}
+	if v, ok := s.Storage.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// ErrSpooled is matched (with errors.Is) by RetryStorage.Store errors when the
+// flush could not be delivered but was accepted into the spool.
+var ErrSpooled = errors.New("spooled for retry")
+
+// spooledError is the error of a flush accepted into the spool. It unwraps
+// to the delivery error and matches ErrSpooled.
+type spooledError struct{ cause error }
+
+func (e *spooledError) Error() string        { return fmt.Sprintf("%v (%v)", e.cause, ErrSpooled) }
+func (e *spooledError) Unwrap() error        { return e.cause }
+func (e *spooledError) Is(target error) bool { return target == ErrSpooled }
+
+// spooledOnly reports whether every delivery failure in err was accepted
+// into a spool. Joined errors, e.g. from MultiStorage, only count if all of
+// them were spooled.
+func spooledOnly(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *spooledError:
+			return true
+		case interface{ Unwrap() []error }:
+			errs := e.Unwrap()
+			for _, err := range errs {
+				if !spooledOnly(err) {
+					return false
+				}
+			}
+			return len(errs) > 0
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// spoolMetaFile is the name of the file holding a spooled flush's Metadata.
+const spoolMetaFile = "spool.json"
+
+// Store delivers spooled flushes, oldest first, and then files. If the
+// backend is still failing, files are spooled without further attempts.
+func (s *RetryStorage) Store(ctx context.Context, files []string, meta Metadata) error {
+	if s.Storage == nil {
+		return fmt.Errorf("goreach/flush: retry: Storage is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.replay(ctx); err != nil {
+		return s.spool(files, meta, err)
+	}
+	if err := s.storeWithRetry(ctx, files, meta); err != nil {
+		return s.spool(files, meta, err)
+	}
+	return nil
+}
+
+// storeWithRetry calls the wrapped Storage until it succeeds, MaxAttempts is
+// reached, or ctx is done.
+func (s *RetryStorage) storeWithRetry(ctx context.Context, files []string, meta Metadata) error {
+	attempts := s.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	var err error
+	for attempt := range attempts {
+		if attempt > 0 {
+			t := time.NewTimer(s.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return fmt.Errorf("%w (after %d attempts: %w)", ctx.Err(), attempt, err)
+			case <-t.C:
+			}
+		}
+		if err = s.Storage.Store(ctx, files, meta); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("goreach/flush: %d attempts failed: %w", attempts, err)
+}
+
+// backoff returns the delay before the given retry (1-based), using
+// exponential growth with "equal jitter": half fixed, half random.
+func (s *RetryStorage) backoff(retry int) time.Duration {
+	base := s.BaseDelay
+	if base <= 0 {
+		base = time.Second
+	}
+	maxDelay := s.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+	d := base
+	for i := 1; i < retry && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// replay delivers spooled flushes in the order they were spooled. It stops
+// at the first failure, leaving the remaining entries in place.
+func (s *RetryStorage) replay(ctx context.Context) error {
+	entries, err := s.spoolEntries()
+	if err != nil {
+		return err
+	}
+	for _, dir := range entries {
+		files, meta, err := readSpoolEntry(dir)
+		if err != nil {
+			// Unreadable entries can never be delivered; drop them.
+			_ = os.RemoveAll(dir)
+			continue
+		}
+		if err := s.storeWithRetry(ctx, files, meta); err != nil {
+			return fmt.Errorf("goreach/flush: replay %s: %w", filepath.Base(dir), err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("goreach/flush: remove spooled %s: %w", filepath.Base(dir), err)
+		}
+	}
+	return nil
+}
+
+// spool copies files into a new spool entry and trims the spool to
+// SpoolMaxBytes. It returns cause, annotated with the spool outcome; the
+// result matches ErrSpooled only if the entry is still in the spool.
+func (s *RetryStorage) spool(files []string, meta Metadata, cause error) error {
+	if s.SpoolDir == "" || len(files) == 0 {
+		return cause
+	}
+
+	dir := filepath.Join(s.SpoolDir, fmt.Sprintf("%020d", time.Now().UnixNano()))
+	if err := writeSpoolEntry(dir, files, meta); err != nil {
+		_ = os.RemoveAll(dir)
+		return fmt.Errorf("%w (spool failed: %w)", cause, err)
+	}
+	trimErr := s.trimSpool()
+	if _, err := os.Stat(dir); err != nil {
+		// Dropped by trimSpool: the flush alone exceeds SpoolMaxBytes.
+		return fmt.Errorf("%w (too large to spool)", cause)
+	}
+	if trimErr != nil {
+		cause = fmt.Errorf("%w (trim spool: %w)", cause, trimErr)
+	}
+	return &spooledError{cause: cause}
+}
+
+// spoolEntries returns the spool entry directories, oldest first.
+func (s *RetryStorage) spoolEntries() ([]string, error) {
+	if s.SpoolDir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.SpoolDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("goreach/flush: read spool: %w", err)
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(s.SpoolDir, e.Name()))
+		}
+	}
+	sort.Strings(dirs) // names are zero-padded timestamps
+	return dirs, nil
+}
+
+// trimSpool removes the oldest entries until the spool fits SpoolMaxBytes.
+func (s *RetryStorage) trimSpool() error {
+	limit := s.SpoolMaxBytes
+	if limit <= 0 {
+		limit = 64 << 20
+	}
+	dirs, err := s.spoolEntries()
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, len(dirs))
+	var total int64
+	for i, dir := range dirs {
+		sizes[i] = dirSize(dir)
+		total += sizes[i]
+	}
+	for i := 0; total > limit && i < len(dirs); i++ {
+		if err := os.RemoveAll(dirs[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+func writeSpoolEntry(dir string, files []string, meta Metadata) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, src := range files {
+		if err := copyFile(src, filepath.Join(dir, filepath.Base(src))); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, spoolMetaFile), data, 0o644)
+}
+
+func readSpoolEntry(dir string) ([]string, Metadata, error) {
+	var meta Metadata
+	data, err := os.ReadFile(filepath.Join(dir, spoolMetaFile))
+	if err != nil {
+		return nil, meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, meta, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, meta, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && e.Name() != spoolMetaFile {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files, meta, nil
+}
+
+// dirSize returns the total size of the regular files directly in dir.
+func dirSize(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var n int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			n += info.Size()
+		}
+	}
+	return n
+}
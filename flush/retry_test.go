@@ -0,0 +1,180 @@
+package flush
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails while down is set and records delivered flushes.
+type flakyStorage struct {
+	mu        sync.Mutex
+	down      bool
+	failFirst int // number of calls to fail before succeeding
+	calls     int
+	delivered []Metadata
+	contents  []string
+	names     []string // base names of the files in contents
+}
+
+func (s *flakyStorage) Store(_ context.Context, files []string, meta Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.down || s.calls <= s.failFirst {
+		return errors.New("backend unavailable")
+	}
+	s.delivered = append(s.delivered, meta)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		s.contents = append(s.contents, string(data))
+		s.names = append(s.names, filepath.Base(f))
+	}
+	return nil
+}
+
+func writeFlushFiles(t *testing.T, content string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "covcounters.abc.1.2")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return []string{path}
+}
+
+func TestRetryStorage_RetriesUntilSuccess(t *testing.T) {
+	backend := &flakyStorage{failFirst: 2}
+	s := &RetryStorage{Storage: backend, MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	if err := s.Store(context.Background(), writeFlushFiles(t, "c1"), Metadata{PodName: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if backend.calls != 3 || len(backend.delivered) != 1 {
+		t.Errorf("calls=%d delivered=%d, want 3 and 1", backend.calls, len(backend.delivered))
+	}
+}
+
+// TestRetryStorage_SpoolAndReplay tests that flushes failing all attempts are
+// spooled and delivered, in order, once the backend recovers.
+func TestRetryStorage_SpoolAndReplay(t *testing.T) {
+	backend := &flakyStorage{down: true}
+	spool := t.TempDir()
+	s := &RetryStorage{Storage: backend, MaxAttempts: 2, BaseDelay: time.Millisecond, SpoolDir: spool}
+
+	for _, c := range []string{"c1", "c2"} {
+		err := s.Store(context.Background(), writeFlushFiles(t, c), Metadata{PodName: c})
+		if !errors.Is(err, ErrSpooled) {
+			t.Fatalf("Store(%s) err = %v, want spooled error", c, err)
+		}
+	}
+	if entries, _ := os.ReadDir(spool); len(entries) != 2 {
+		t.Fatalf("spool has %d entries, want 2", len(entries))
+	}
+
+	backend.down = false
+	if err := s.Store(context.Background(), writeFlushFiles(t, "c3"), Metadata{PodName: "c3"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(backend.contents, ","); got != "c1,c2,c3" {
+		t.Errorf("delivered %q, want c1,c2,c3", got)
+	}
+	if backend.delivered[0].PodName != "c1" {
+		t.Errorf("replayed metadata = %+v", backend.delivered[0])
+	}
+	if entries, _ := os.ReadDir(spool); len(entries) != 0 {
+		t.Errorf("spool has %d entries after replay, want 0", len(entries))
+	}
+}
+
+// TestRetryStorage_ClearCountsOnce tests that with Config.Clear, counters
+// spooled during an outage are cleared and so delivered exactly once.
+func TestRetryStorage_ClearCountsOnce(t *testing.T) {
+	fakeCoverage(t)
+	hits := 0
+	writeCountersDir = func(dir string) error {
+		return os.WriteFile(filepath.Join(dir, "covcounters."+fakeMetaHash+".1.2"), []byte(strconv.Itoa(hits)), 0o644)
+	}
+	clearCounters = func() error {
+		hits = 0
+		return nil
+	}
+
+	backend := &flakyStorage{down: true}
+	storage := &RetryStorage{Storage: backend, MaxAttempts: 1, SpoolDir: t.TempDir()}
+	f := New(Config{Storage: storage, Clear: true})
+	defer f.Stop()
+
+	hits = 1
+	if err := f.Emit(); !errors.Is(err, ErrSpooled) {
+		t.Fatalf("Emit err = %v, want ErrSpooled", err)
+	}
+	if st := f.Stats(); st.Failures != 1 {
+		t.Errorf("Failures = %d, want spooled flush reported", st.Failures)
+	}
+
+	hits += 2
+	backend.down = false
+	if err := f.Emit(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for i, name := range backend.names {
+		if strings.HasPrefix(name, "covcounters.") {
+			n, err := strconv.Atoi(backend.contents[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += n
+		}
+	}
+	if total != 3 {
+		t.Errorf("delivered %d hits, want 3", total)
+	}
+}
+
+func TestRetryStorage_SpoolBound(t *testing.T) {
+	backend := &flakyStorage{down: true}
+	spool := t.TempDir()
+	s := &RetryStorage{Storage: backend, MaxAttempts: 1, SpoolDir: spool, SpoolMaxBytes: 200}
+
+	for range 5 {
+		_ = s.Store(context.Background(), writeFlushFiles(t, strings.Repeat("x", 60)), Metadata{})
+	}
+	var total int64
+	entries, _ := os.ReadDir(spool)
+	for _, e := range entries {
+		total += dirSize(filepath.Join(spool, e.Name()))
+	}
+	if total > 200 {
+		t.Errorf("spool size = %d, want <= 200", total)
+	}
+	if len(entries) == 0 || len(entries) == 5 {
+		t.Errorf("spool has %d entries, want oldest dropped", len(entries))
+	}
+}
+
+func TestRetryStorage_ContextCanceled(t *testing.T) {
+	backend := &flakyStorage{down: true}
+	s := &RetryStorage{Storage: backend, MaxAttempts: 5, BaseDelay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := s.Store(ctx, writeFlushFiles(t, "c1"), Metadata{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if backend.calls != 1 {
+		t.Errorf("calls = %d, want 1", backend.calls)
+	}
+}
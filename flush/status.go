@@ -0,0 +1,80 @@
+package flush
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/yag13s/goreach/internal/covmeta"
+)
+
+// Status describes the configuration and state of a Flusher, for status
+// pages and health checks.
+type Status struct {
+	Enabled   bool   `json:"enabled"`              // built with -cover, and the Flusher is not stopped
+	CoverMode string `json:"cover_mode,omitempty"` // "set", "count" or "atomic"
+
+	ServiceName  string            `json:"service_name,omitempty"`
+	BuildVersion string            `json:"build_version,omitempty"`
+	BuildTime    time.Time         `json:"build_time,omitzero"`
+	Labels       map[string]string `json:"labels,omitempty"`
+
+	Interval    time.Duration `json:"interval"`               // periodic flush interval in nanoseconds; 0 if disabled
+	Storage     string        `json:"storage,omitempty"`      // Go type of Config.Storage, e.g. "*objstore.Storage"
+	ConfigError string        `json:"config_error,omitempty"` // from Config.Validate
+
+	Stats Stats `json:"stats"`
+}
+
+// Status returns the configuration and state of f.
+func (f *Flusher) Status() Status {
+	f.mu.Lock()
+	enabled := f.active && !f.stopped
+	f.mu.Unlock()
+
+	meta := f.Metadata()
+	st := Status{
+		Enabled:      enabled,
+		CoverMode:    coverMode(),
+		ServiceName:  meta.ServiceName,
+		BuildVersion: meta.BuildVersion,
+		BuildTime:    meta.BuildTime,
+		Labels:       meta.Labels,
+		Interval:     f.cfg.Interval,
+		Storage:      fmt.Sprintf("%T", f.cfg.Storage),
+		Stats:        f.Stats(),
+	}
+	if f.cfgErr != nil {
+		st.ConfigError = f.cfgErr.Error()
+	}
+	return st
+}
+
+// DefaultStatus returns the Status of the default Flusher, or a disabled
+// Status describing the binary if flushing is not enabled.
+func DefaultStatus() Status {
+	if f := Default(); f != nil {
+		return f.Status()
+	}
+	meta := Config{}.Metadata()
+	return Status{
+		CoverMode:    coverMode(),
+		ServiceName:  meta.ServiceName,
+		BuildVersion: meta.BuildVersion,
+		BuildTime:    meta.BuildTime,
+	}
+}
+
+// coverMode returns the -covermode of the binary, or "" if it was not built
+// with -cover.
+func coverMode() string {
+	if !coverageAvailable() {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := writeMeta(&buf); err != nil {
+		return ""
+	}
+	h, _ := covmeta.ParseHeader(buf.Bytes())
+	return h.Mode
+}
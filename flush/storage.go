@@ -2,10 +2,12 @@ package flush
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -16,13 +18,71 @@ type Storage interface {
 	Store(ctx context.Context, files []string, meta Metadata) error
 }
 
+// Validator is implemented by Storages that can check their configuration
+// before the first flush. New validates Config.Storage if it implements
+// Validator.
+type Validator interface {
+	Validate() error
+}
+
 // Metadata carries information associated with coverage data.
+// Every flush also stores it as JSON in a manifest; see [ManifestName].
 type Metadata struct {
-	Timestamp    time.Time
-	Hostname     string
-	PodName      string // auto-populated from POD_NAME env var (k8s downward API)
-	BuildVersion string // build version or commit hash (set via Config)
-	ServiceName  string // service identifier (set via Config)
+	Timestamp    time.Time `json:"timestamp"`
+	Hostname     string    `json:"hostname,omitempty"`
+	PodName      string    `json:"pod_name,omitempty"`      // auto-populated from POD_NAME env var (k8s downward API)
+	BuildVersion string    `json:"build_version,omitempty"` // build version or commit hash (set via Config)
+	BuildTime    time.Time `json:"build_time,omitzero"`     // commit time of the build (set via Config or build info)
+	ServiceName  string    `json:"service_name,omitempty"`  // service identifier (set via Config)
+
+	Labels map[string]string `json:"labels,omitempty"` // Config.Labels and captured Config.LabelEnv values
+}
+
+// ManifestName returns the name of the JSON manifest written next to the
+// covmeta/covcounters files of a flush, given the name of the flush's
+// covcounters file: covcounters.<hash>.<pid>.<nanos> is described by
+// goreach-manifest.<hash>.<pid>.<nanos>.json. The manifest holds the flush's
+// Metadata so that tools reading the files later (goreach analyze -r) do not
+// have to rely on file modification times or storage paths. Naming it per
+// flush keeps processes that share a GOCOVERDIR from overwriting each
+// other's manifests.
+func ManifestName(counters string) string {
+	return manifestPrefix + strings.TrimPrefix(counters, "covcounters.") + manifestSuffix
+}
+
+const (
+	manifestPrefix = "goreach-manifest."
+	manifestSuffix = ".json"
+)
+
+// IsManifest reports whether name is a manifest name returned by
+// ManifestName.
+func IsManifest(name string) bool {
+	return strings.HasPrefix(name, manifestPrefix) && strings.HasSuffix(name, manifestSuffix) &&
+		len(name) > len(manifestPrefix)+len(manifestSuffix)
+}
+
+// writeManifest writes meta into dir as the manifest of the flush with the
+// given covcounters file and returns its path.
+func writeManifest(dir, counters string, meta Metadata) (string, error) {
+	data, err := encodeManifest(meta)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, ManifestName(counters))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// encodeManifest returns the manifest contents for meta.
+func encodeManifest(meta Metadata) ([]byte, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
 }
 
 // LocalStorage saves coverage files to a local directory in GOCOVERDIR-compatible layout.
@@ -77,14 +137,5 @@ func copyFile(src, dst string) error {
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return err
-	}
-	return out.Close()
+	return writeFile(dst, in)
 }
@@ -0,0 +1,105 @@
+package flush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yag13s/goreach/internal/covmeta"
+)
+
+// StreamStorage is an optional interface for Storages that can consume
+// coverage data as streams. doFlush prefers it over Store: the data is
+// produced in memory and no temporary directory is created, so flushing
+// works on read-only root filesystems and in containers without a writable
+// /tmp.
+type StreamStorage interface {
+	Storage
+
+	// StoreStreams saves the coverage files of one flush (covmeta,
+	// covcounters and the manifest). Each Body is valid only for the
+	// duration of the call.
+	StoreStreams(ctx context.Context, files []StreamFile, meta Metadata) error
+}
+
+// StreamFile is a coverage file passed to [StreamStorage].
+type StreamFile struct {
+	Name string // file name in GOCOVERDIR layout, e.g. "covmeta.<hash>"
+	Size int64
+	Body io.Reader
+}
+
+// doFlushStream is doFlush for a StreamStorage: meta-data and counters are
+// written to memory and named the way coverage.WriteMetaDir and
+// WriteCountersDir would name them.
+func doFlushStream(ctx context.Context, cfg Config, ss StreamStorage) (int64, error) {
+	var metaBuf, countersBuf bytes.Buffer
+	if err := writeMeta(&metaBuf); err != nil {
+		return 0, fmt.Errorf("goreach/flush: write meta: %w", err)
+	}
+	if err := writeCounters(&countersBuf); err != nil {
+		return 0, fmt.Errorf("goreach/flush: write counters: %w", err)
+	}
+
+	header, err := covmeta.ParseHeader(metaBuf.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("goreach/flush: write meta: %w", err)
+	}
+	hash := header.Hash
+	metaName := "covmeta." + hash
+	countersName := fmt.Sprintf("covcounters.%s.%d.%d", hash, os.Getpid(), time.Now().UnixNano())
+
+	meta := cfg.metadata([]string{metaName})
+	manifest, err := encodeManifest(meta)
+	if err != nil {
+		return 0, fmt.Errorf("goreach/flush: write manifest: %w", err)
+	}
+
+	files := []StreamFile{
+		{Name: metaName, Size: int64(metaBuf.Len()), Body: &metaBuf},
+		{Name: countersName, Size: int64(countersBuf.Len()), Body: &countersBuf},
+		{Name: ManifestName(countersName), Size: int64(len(manifest)), Body: bytes.NewReader(manifest)},
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+
+	if err := finishStore(cfg, ss.StoreStreams(ctx, files, meta)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// compile-time check
+var _ StreamStorage = LocalStorage{}
+
+// StoreStreams writes files to s.Dir.
+func (s LocalStorage) StoreStreams(_ context.Context, files []StreamFile, _ Metadata) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("goreach/flush: mkdir %s: %w", s.Dir, err)
+	}
+	for _, f := range files {
+		if err := writeFile(filepath.Join(s.Dir, f.Name), f.Body); err != nil {
+			return fmt.Errorf("goreach/flush: write %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func writeFile(dst string, r io.Reader) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	return out.Close()
+}
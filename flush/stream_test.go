@@ -0,0 +1,66 @@
+package flush
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestFlush_Stream tests that LocalStorage receives streamed data named in
+// GOCOVERDIR layout and that no temporary directory is needed.
+func TestFlush_Stream(t *testing.T) {
+	fakeCoverage(t)
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	dir := t.TempDir()
+	f := New(Config{Storage: LocalStorage{Dir: dir}})
+	defer f.Stop()
+	if err := f.Emit(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 3 {
+		t.Fatalf("files = %v, want covmeta, covcounters and manifest", names)
+	}
+	for _, name := range names {
+		switch {
+		case name == "covmeta."+fakeMetaHash:
+		case IsManifest(name):
+			if !slices.ContainsFunc(names, func(n string) bool { return strings.HasPrefix(n, "covcounters.") && ManifestName(n) == name }) {
+				t.Errorf("manifest %s is not named after the covcounters file", name)
+			}
+		case strings.HasPrefix(name, "covcounters."+fakeMetaHash+"."):
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			if string(data) != "counters" {
+				t.Errorf("%s = %q", name, data)
+			}
+		default:
+			t.Errorf("unexpected file %s", name)
+		}
+	}
+}
+
+func TestFlush_StreamShortMeta(t *testing.T) {
+	fakeCoverage(t)
+	writeMeta = func(w io.Writer) error {
+		_, err := w.Write([]byte("meta"))
+		return err
+	}
+
+	_, err := doFlush(context.Background(), Config{Storage: LocalStorage{Dir: t.TempDir()}})
+	if err == nil || !strings.Contains(err.Error(), "short meta-data") {
+		t.Errorf("err = %v, want short meta-data error", err)
+	}
+}
@@ -3,6 +3,7 @@ package analysis
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"path/filepath"
@@ -12,6 +13,7 @@ import (
 	"golang.org/x/tools/cover"
 
 	"github.com/yag13s/goreach/internal/astmap"
+	"github.com/yag13s/goreach/internal/covparse"
 	"github.com/yag13s/goreach/internal/report"
 )
 
@@ -29,6 +31,13 @@ type Options struct {
 	MinStatements int
 }
 
+// fileFuncsFunc returns the functions of the source file of prof.
+type fileFuncsFunc func(importPath string, prof *cover.Profile) ([]*astmap.FuncExtent, error)
+
+// errNoSource is returned by a fileFuncsFunc for a package whose source
+// directory is unknown.
+var errNoSource = errors.New("analysis: package source not found")
+
 // Run performs the full analysis pipeline: parse profiles, resolve sources,
 // extract AST, match coverage blocks, and return a report.
 func Run(profiles []*cover.Profile, opts Options) (*report.Report, error) {
@@ -41,6 +50,40 @@ func Run(profiles []*cover.Profile, opts Options) (*report.Report, error) {
 		return nil, err
 	}
 
+	return run(profiles, pkgFiles, opts, func(importPath string, prof *cover.Profile) ([]*astmap.FuncExtent, error) {
+		diskDir, ok := pkgPaths[importPath]
+		if !ok {
+			return nil, errNoSource
+		}
+		return astmap.FileFuncs(filepath.Join(diskDir, filepath.Base(prof.FileName)))
+	}), nil
+}
+
+// RunMeta is like Run but takes the functions from coverage meta-data (see
+// covparse.MetaFuncRanges) instead of the source AST, so it needs neither
+// the source tree nor the go command. A function's Line is the line of its
+// first statement rather than of its declaration.
+func RunMeta(profiles []*cover.Profile, funcs []covparse.FuncRange, opts Options) *report.Report {
+	byFile := make(map[string][]*astmap.FuncExtent)
+	for _, f := range funcs {
+		byFile[f.FileName] = append(byFile[f.FileName], &astmap.FuncExtent{
+			Name:      f.FuncName,
+			StartLine: f.StartLine,
+			StartCol:  f.StartCol,
+			EndLine:   f.EndLine,
+			EndCol:    f.EndCol,
+		})
+	}
+	for _, extents := range byFile {
+		sort.Slice(extents, func(i, j int) bool { return extents[i].StartLine < extents[j].StartLine })
+	}
+
+	return run(profiles, groupByPackage(profiles), opts, func(_ string, prof *cover.Profile) ([]*astmap.FuncExtent, error) {
+		return byFile[prof.FileName], nil
+	})
+}
+
+func run(profiles []*cover.Profile, pkgFiles map[string][]*cover.Profile, opts Options, fileFuncs fileFuncsFunc) *report.Report {
 	var pkgReports []report.PackageReport
 	var totalStmts, totalCovered int
 
@@ -57,12 +100,7 @@ func Run(profiles []*cover.Profile, opts Options) (*report.Report, error) {
 			continue
 		}
 
-		diskDir, ok := pkgPaths[importPath]
-		if !ok {
-			continue
-		}
-
-		pkgReport := analyzePackage(importPath, diskDir, profs, opts)
+		pkgReport := analyzePackage(importPath, profs, opts, fileFuncs)
 		if pkgReport == nil {
 			continue
 		}
@@ -86,10 +124,10 @@ func Run(profiles []*cover.Profile, opts Options) (*report.Report, error) {
 			CoveragePercent:   report.ComputePercent(totalCovered, totalStmts),
 		},
 		Packages: pkgReports,
-	}, nil
+	}
 }
 
-func analyzePackage(importPath, diskDir string, profiles []*cover.Profile, opts Options) *report.PackageReport {
+func analyzePackage(importPath string, profiles []*cover.Profile, opts Options, fileFuncs fileFuncsFunc) *report.PackageReport {
 	var fileReports []report.FileReport
 	var pkgStmts, pkgCovered int
 
@@ -99,10 +137,7 @@ func analyzePackage(importPath, diskDir string, profiles []*cover.Profile, opts
 	})
 
 	for _, prof := range profiles {
-		baseName := filepath.Base(prof.FileName)
-		srcPath := filepath.Join(diskDir, baseName)
-
-		funcs, err := astmap.FileFuncs(srcPath)
+		funcs, err := fileFuncs(importPath, prof)
 		if err != nil {
 			continue
 		}
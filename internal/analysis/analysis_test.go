@@ -1,11 +1,15 @@
 package analysis
 
 import (
+	"fmt"
+	"os"
+	"slices"
 	"testing"
 
 	"golang.org/x/tools/cover"
 
 	"github.com/yag13s/goreach/internal/astmap"
+	"github.com/yag13s/goreach/internal/covparse"
 	"github.com/yag13s/goreach/internal/report"
 )
 
@@ -412,6 +416,49 @@ func TestRunWithPkgPrefixFilter(t *testing.T) {
 	}
 }
 
+// TestRunMeta tests that RunMeta attributes blocks to the functions recorded
+// in coverage meta-data, folding function literals into their enclosing
+// function.
+func TestRunMeta(t *testing.T) {
+	dir := "../covparse/testdata/covdata"
+	profiles, err := covparse.ReadProfiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := os.ReadFile(dir + "/covmeta.3ce4e58830a5b292ef51542c36b6ae57")
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs, err := covparse.MetaFuncRanges(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpt := RunMeta(profiles, funcs, Options{Threshold: 80})
+	if rpt.Mode != "atomic" {
+		t.Errorf("Mode = %q, want atomic", rpt.Mode)
+	}
+	if rpt.Total.TotalStatements != 19 || rpt.Total.CoveredStatements != 13 {
+		t.Errorf("Total = %+v, want 13 of 19 statements", rpt.Total)
+	}
+	if len(rpt.Packages) != 1 || len(rpt.Packages[0].Files) != 1 {
+		t.Fatalf("expected 1 package with 1 file, got %+v", rpt.Packages)
+	}
+
+	var got []string
+	for _, fn := range rpt.Packages[0].Files[0].Functions {
+		got = append(got, fmt.Sprintf("%s:%d %.0f%%", fn.Name, fn.Line, fn.CoveragePercent))
+	}
+	want := []string{"(Greeter).Name:17 0%", "classify:20 75%", "unused:30 0%"}
+	if !slices.Equal(got, want) {
+		t.Errorf("functions = %v, want %v", got, want)
+	}
+
+	if rpt := RunMeta(profiles, funcs, Options{PkgPrefixes: []string{"example.com/other"}, Threshold: 100}); len(rpt.Packages) != 0 {
+		t.Errorf("expected no packages outside the prefix, got %+v", rpt.Packages)
+	}
+}
+
 // TestBlockOverlapsFunc_SameLineEdgeCases tests the column-level edge cases
 // on the same line boundaries.
 func TestBlockOverlapsFunc_SameLineEdgeCases(t *testing.T) {
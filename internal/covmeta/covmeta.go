@@ -0,0 +1,57 @@
+// Package covmeta parses the header of covmeta files, the coverage
+// meta-data written by binaries built with -cover (see internal/coverage
+// in the Go distribution). The flush SDK reads the hash and counter mode of
+// its own meta-data from it; covparse decodes the rest of the file.
+package covmeta
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// HeaderLen is the size of the covmeta file header (MetaFileHeader).
+const HeaderLen = 56
+
+const (
+	magic   = "\x00cvm"
+	version = 1
+)
+
+// modes maps the on-disk counter mode to the text profile mode name.
+var modes = map[byte]string{1: "set", 2: "count", 3: "atomic"}
+
+// Header holds the fields of a covmeta file header.
+type Header struct {
+	Entries     uint64 // number of packages
+	Hash        string // hex meta-data hash, as in covmeta and covcounters file names
+	Mode        string // counter mode: "set", "count" or "atomic"
+	Granularity byte   // counter granularity: 1 per block, 2 per function
+}
+
+// ParseHeader parses the header at the start of covmeta data b, e.g. a
+// covmeta file or the output of runtime/coverage.WriteMeta.
+func ParseHeader(b []byte) (Header, error) {
+	if len(b) < HeaderLen {
+		return Header{}, fmt.Errorf("short meta-data header (%d bytes)", len(b))
+	}
+	if string(b[:len(magic)]) != magic {
+		return Header{}, errors.New("invalid meta-data file magic")
+	}
+	if v := binary.LittleEndian.Uint32(b[4:]); v > version {
+		return Header{}, fmt.Errorf("unsupported meta-data file version %d", v)
+	}
+	// b[8:16] holds the total length, b[40:48] the string table offset and
+	// length; goreach uses neither.
+	mode, ok := modes[b[48]]
+	if !ok {
+		return Header{}, fmt.Errorf("unsupported counter mode %d", b[48])
+	}
+	return Header{
+		Entries:     binary.LittleEndian.Uint64(b[16:]),
+		Hash:        hex.EncodeToString(b[24:40]),
+		Mode:        mode,
+		Granularity: b[49],
+	}, nil
+}
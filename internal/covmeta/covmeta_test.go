@@ -0,0 +1,33 @@
+package covmeta
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	data, err := os.ReadFile("../covparse/testdata/covdata/covmeta.3ce4e58830a5b292ef51542c36b6ae57")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := ParseHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Hash != "3ce4e58830a5b292ef51542c36b6ae57" || h.Mode == "" || h.Entries == 0 {
+		t.Errorf("ParseHeader = %+v, want the file's hash, a mode and packages", h)
+	}
+
+	if _, err := ParseHeader(data[:HeaderLen-1]); err == nil {
+		t.Error("expected error for short header")
+	}
+	bad := append([]byte("xcvm"), data[4:]...)
+	if _, err := ParseHeader(bad); err == nil {
+		t.Error("expected error for wrong magic")
+	}
+	bad = append([]byte(nil), data...)
+	bad[48] = 9
+	if _, err := ParseHeader(bad); err == nil {
+		t.Error("expected error for unknown counter mode")
+	}
+}
@@ -0,0 +1,90 @@
+package covparse
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isBundle reports whether path names a flush bundle written by
+// flush/objstore with Bundle set: a gzip-compressed tar of one flush's
+// covmeta, covcounters and manifest files.
+func isBundle(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, "covbundle.") && strings.HasSuffix(base, ".tar.gz")
+}
+
+// maxBundleFile bounds the size of a single file extracted from a bundle.
+const maxBundleFile = 256 << 20
+
+// sourceFile is a file of a coverage source: a directory or a bundle.
+type sourceFile struct {
+	name string
+	read func() ([]byte, error)
+}
+
+// listSource returns the files of a coverage source. A source is either a
+// GOCOVERDIR-style directory or a bundle, which is read into memory.
+func listSource(path string) ([]sourceFile, error) {
+	if isBundle(path) {
+		return readBundle(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("covparse: read dir %s: %w", path, err)
+	}
+	var files []sourceFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := filepath.Join(path, e.Name())
+		files = append(files, sourceFile{name: e.Name(), read: func() ([]byte, error) { return os.ReadFile(p) }})
+	}
+	return files, nil
+}
+
+// readBundle returns the regular files in the bundle at path.
+func readBundle(path string) ([]sourceFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("covparse: read bundle: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("covparse: read bundle %s: %w", path, err)
+	}
+	tr := tar.NewReader(zr)
+	var files []sourceFile
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("covparse: read bundle %s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxBundleFile {
+			return nil, fmt.Errorf("covparse: read bundle %s: %s is too large (%d bytes)", path, hdr.Name, hdr.Size)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("covparse: read bundle %s: %w", path, err)
+		}
+		files = append(files, sourceFile{
+			name: filepath.Base(hdr.Name),
+			read: func() ([]byte, error) { return data, nil },
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,112 @@
+package covparse
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBundle packs the files of srcDir plus a manifest for each of its
+// covcounters files into a bundle at path.
+func writeBundle(t *testing.T, path, srcDir, manifest string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(srcDir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(e.Name(), data)
+		if id, ok := strings.CutPrefix(e.Name(), "covcounters."); ok {
+			add(manifestPrefix+id+manifestSuffix, []byte(manifest))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestParseDirRecursiveGrouped_Bundle tests that bundles found by the walker
+// are expanded and decode to the same profile as the loose files.
+func TestParseDirRecursiveGrouped_Bundle(t *testing.T) {
+	want, err := ParseDir("testdata/covdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	writeBundle(t, filepath.Join(root, "svc", "v1", "pod-0", "covbundle.1.tar.gz"), "testdata/covdata",
+		`{"timestamp":"2025-01-01T00:00:00Z","build_version":"v1","labels":{"region":"eu"}}`)
+
+	groups, err := ParseDirRecursiveGrouped(root, LabelSelector{"region": "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Dirs) != 1 || !isBundle(groups[0].Dirs[0]) {
+		t.Fatalf("groups = %+v, want one group with the bundle", groups)
+	}
+	if groups[0].BuildVersion != "v1" {
+		t.Errorf("BuildVersion = %q, want v1", groups[0].BuildVersion)
+	}
+	got, err := groups[0].ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("bundle profile differs from loose files:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseDir_Bundles(t *testing.T) {
+	want, err := ParseDir("testdata/covdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeBundle(t, filepath.Join(dir, "covbundle.1.tar.gz"), "testdata/covdata", `{}`)
+	got, err := ParseDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("ParseDir with bundle = \n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReadBundle_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "covbundle.1.tar.gz")
+	if err := os.WriteFile(path, []byte("not gzip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDir(filepath.Dir(path)); err == nil {
+		t.Error("expected error for corrupt bundle")
+	}
+}
@@ -0,0 +1,131 @@
+package covparse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Warnf reports recoverable problems in the coverage data, such as
+// interrupted flushes that are skipped. It prints to stderr by default.
+var Warnf = func(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "goreach: warning: "+format+"\n", args...)
+}
+
+// isCommitMarker reports whether name is a commit marker written by
+// flush/objstore with CommitMarker set.
+func isCommitMarker(name string) bool {
+	return strings.HasPrefix(name, "goreach-commit.") && strings.HasSuffix(name, ".json")
+}
+
+// commitMarker lists the covmeta/covcounters files of one completed flush.
+type commitMarker struct {
+	Files []struct {
+		Name   string `json:"name"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	} `json:"files"`
+}
+
+// hasCommitMarker reports whether files contain a commit marker.
+func hasCommitMarker(files []sourceFile) bool {
+	for _, f := range files {
+		if isCommitMarker(f.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// committedFiles returns files without the coverage files that are not part
+// of a complete flush. If the source has no commit markers, all files are
+// returned. Otherwise a coverage file is kept only if a marker lists it and
+// every file of that marker is present with the recorded size and checksum.
+// Skipped flushes are reported via Warnf if warn is set.
+func committedFiles(dir string, files []sourceFile, warn bool) ([]sourceFile, error) {
+	if !hasCommitMarker(files) {
+		return files, nil
+	}
+
+	byName := make(map[string]sourceFile, len(files))
+	for _, f := range files {
+		byName[f.name] = f
+	}
+	sums := make(map[string]string) // file name -> "size:sha256", computed lazily
+	sum := func(f sourceFile) (string, error) {
+		if s, ok := sums[f.name]; ok {
+			return s, nil
+		}
+		data, err := f.read()
+		if err != nil {
+			return "", fmt.Errorf("covparse: read %s/%s: %w", dir, f.name, err)
+		}
+		h := sha256.Sum256(data)
+		s := fmt.Sprintf("%d:%s", len(data), hex.EncodeToString(h[:]))
+		sums[f.name] = s
+		return s, nil
+	}
+
+	committed := make(map[string]bool)
+	for _, f := range files {
+		if !isCommitMarker(f.name) {
+			continue
+		}
+		data, err := f.read()
+		if err != nil {
+			return nil, fmt.Errorf("covparse: read %s/%s: %w", dir, f.name, err)
+		}
+		var m commitMarker
+		if err := json.Unmarshal(data, &m); err != nil {
+			if warn {
+				Warnf("%s/%s: invalid commit marker: %v", dir, f.name, err)
+			}
+			continue
+		}
+		complete := true
+		for _, mf := range m.Files {
+			sf, ok := byName[mf.Name]
+			if !ok {
+				if warn {
+					Warnf("%s: skipping flush %s: %s is missing", dir, f.name, mf.Name)
+				}
+				complete = false
+				break
+			}
+			got, err := sum(sf)
+			if err != nil {
+				return nil, err
+			}
+			if got != fmt.Sprintf("%d:%s", mf.Size, mf.SHA256) {
+				if warn {
+					Warnf("%s: skipping flush %s: %s does not match its checksum", dir, f.name, mf.Name)
+				}
+				complete = false
+				break
+			}
+		}
+		if complete {
+			for _, mf := range m.Files {
+				committed[mf.Name] = true
+			}
+		}
+	}
+
+	var kept []sourceFile
+	var skipped int
+	for _, f := range files {
+		isCoverage := strings.HasPrefix(f.name, "covmeta.") || strings.HasPrefix(f.name, "covcounters.")
+		if isCoverage && !committed[f.name] {
+			skipped++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if skipped > 0 && warn {
+		Warnf("%s: ignoring %d coverage file(s) without a valid commit marker", dir, skipped)
+	}
+	return kept, nil
+}
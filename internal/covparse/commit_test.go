@@ -0,0 +1,151 @@
+package covparse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testMeta      = "covmeta.3ce4e58830a5b292ef51542c36b6ae57"
+	testCounters1 = "covcounters.3ce4e58830a5b292ef51542c36b6ae57.6591.1792124581563684758"
+	testCounters2 = "covcounters.3ce4e58830a5b292ef51542c36b6ae57.6594.1792124581564980415"
+)
+
+// captureWarnings collects Warnf output for the duration of the test.
+func captureWarnings(t *testing.T) *[]string {
+	t.Helper()
+	var warnings []string
+	orig := Warnf
+	t.Cleanup(func() { Warnf = orig })
+	Warnf = func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+	return &warnings
+}
+
+// copyCovdata copies the named testdata/covdata files into dir.
+func copyCovdata(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("testdata/covdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeMarker writes a commit marker for the named files in dir. corrupt
+// names a file whose checksum is recorded wrongly.
+func writeMarker(t *testing.T, dir, marker, corrupt string, names ...string) {
+	t.Helper()
+	type file struct {
+		Name   string `json:"name"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+	var m struct {
+		Files []file `json:"files"`
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if name == corrupt {
+			sum[0]++
+		}
+		m.Files = append(m.Files, file{Name: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, marker), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestParseDir_CommitMarkers tests that only files of complete flushes are
+// read once a directory has commit markers.
+func TestParseDir_CommitMarkers(t *testing.T) {
+	wantDir := filepath.Join(t.TempDir(), "want")
+	copyCovdata(t, wantDir, testMeta, testCounters1)
+	want, err := ParseDir(wantDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(dir string)
+		warning string
+	}{
+		{"uncommitted counters", func(dir string) {
+			writeMarker(t, dir, "goreach-commit.1.json", "", testMeta, testCounters1)
+		}, "ignoring 1 coverage file(s)"},
+		{"checksum mismatch", func(dir string) {
+			writeMarker(t, dir, "goreach-commit.1.json", "", testMeta, testCounters1)
+			writeMarker(t, dir, "goreach-commit.2.json", testCounters2, testMeta, testCounters2)
+		}, "does not match its checksum"},
+		{"missing file", func(dir string) {
+			writeMarker(t, dir, "goreach-commit.1.json", "", testMeta, testCounters1)
+			writeMarker(t, dir, "goreach-commit.2.json", "", testMeta, testCounters2)
+			if err := os.Remove(filepath.Join(dir, testCounters2)); err != nil {
+				t.Fatal(err)
+			}
+		}, "is missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := captureWarnings(t)
+			dir := t.TempDir()
+			copyCovdata(t, dir, testMeta, testCounters1, testCounters2)
+			tt.setup(dir)
+
+			got, err := ParseDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("profile includes uncommitted data:\n%s\nwant:\n%s", got, want)
+			}
+			if !strings.Contains(strings.Join(*warnings, "\n"), tt.warning) {
+				t.Errorf("warnings = %q, want %q", *warnings, tt.warning)
+			}
+		})
+	}
+}
+
+// TestFindCoverageDirs_SkipsUnmarked tests that once commit markers are in
+// use under a root, directories without any marker are skipped.
+func TestFindCoverageDirs_SkipsUnmarked(t *testing.T) {
+	warnings := captureWarnings(t)
+	root := t.TempDir()
+	committed := filepath.Join(root, "pod-0")
+	partial := filepath.Join(root, "pod-1")
+	copyCovdata(t, committed, testMeta, testCounters1)
+	writeMarker(t, committed, "goreach-commit.1.json", "", testMeta, testCounters1)
+	copyCovdata(t, partial, testMeta)
+
+	dirs, err := findCoverageDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != committed {
+		t.Errorf("dirs = %v, want [%s]", dirs, committed)
+	}
+	if len(*warnings) != 1 || !strings.Contains((*warnings)[0], "pod-1") {
+		t.Errorf("warnings = %q", *warnings)
+	}
+}
@@ -3,29 +3,57 @@ package covparse
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/tools/cover"
 )
 
 // BuildGroup represents a set of coverage directories that share the same
 // covmeta hash set (i.e. they were produced by the same build).
 type BuildGroup struct {
-	Dirs            []string
-	NewestTimestamp time.Time // newest covcounters file ModTime in the group
+	Dirs []string
+
+	// NewestTimestamp is the newest flush time in the group: the manifest
+	// timestamp for directories written by the flush SDK, the newest
+	// covcounters file ModTime otherwise.
+	NewestTimestamp time.Time
+
+	// BuildVersion is the build version recorded in the newest manifest, or
+	// empty if the group has no manifest.
+	BuildVersion string
+
+	// Labels restricts the group to the flushes whose manifest matches it.
+	// Directories may hold flushes with different labels when processes
+	// share a GOCOVERDIR. Empty selects every flush.
+	Labels LabelSelector
 }
 
 // ParseProfile merges the group's coverage directories and returns a text profile.
 func (g BuildGroup) ParseProfile() (string, error) {
-	return mergeAndParse(g.Dirs)
+	return parseDirs(g.Dirs, g.Labels)
+}
+
+// Profiles merges the group's coverage directories and returns the parsed profiles.
+func (g BuildGroup) Profiles() ([]*cover.Profile, error) {
+	return readProfiles(g.Dirs, g.Labels)
+}
+
+// FuncCoverage returns per-function coverage data for the group.
+func (g BuildGroup) FuncCoverage() ([]FuncCoverage, error) {
+	data, err := readDirs(g.Dirs, g.Labels)
+	if err != nil {
+		return nil, err
+	}
+	return data.funcCoverage(), nil
 }
 
 // ParseDirRecursiveGrouped walks dir recursively, groups coverage directories
-// by covmeta hash, and returns BuildGroups sorted by newest covcounters
-// timestamp ascending (last element = newest build).
-func ParseDirRecursiveGrouped(dir string) ([]BuildGroup, error) {
+// by covmeta hash, and returns BuildGroups sorted by NewestTimestamp
+// ascending (last element = newest build), then by BuildVersion.
+// Only directories whose flush manifest matches sel are included.
+func ParseDirRecursiveGrouped(dir string, sel LabelSelector) ([]BuildGroup, error) {
 	covDirs, err := findCoverageDirs(dir)
 	if err != nil {
 		return nil, err
@@ -33,6 +61,13 @@ func ParseDirRecursiveGrouped(dir string) ([]BuildGroup, error) {
 	if len(covDirs) == 0 {
 		return nil, fmt.Errorf("covparse: no coverage data found under %s", dir)
 	}
+	covDirs, err = SelectDirs(covDirs, sel)
+	if err != nil {
+		return nil, err
+	}
+	if len(covDirs) == 0 {
+		return nil, fmt.Errorf("covparse: no coverage data matching labels %s found under %s", sel, dir)
+	}
 
 	hashGroups, err := groupByMetaHash(covDirs)
 	if err != nil {
@@ -41,25 +76,78 @@ func ParseDirRecursiveGrouped(dir string) ([]BuildGroup, error) {
 
 	groups := make([]BuildGroup, 0, len(hashGroups))
 	for _, dirs := range hashGroups {
-		ts, tsErr := newestCounterTime(dirs)
-		if tsErr != nil {
-			return nil, tsErr
+		g, gErr := newBuildGroup(dirs, sel)
+		if gErr != nil {
+			return nil, gErr
 		}
-		groups = append(groups, BuildGroup{Dirs: dirs, NewestTimestamp: ts})
+		groups = append(groups, g)
 	}
 
 	sort.Slice(groups, func(i, j int) bool {
-		return groups[i].NewestTimestamp.Before(groups[j].NewestTimestamp)
+		if !groups[i].NewestTimestamp.Equal(groups[j].NewestTimestamp) {
+			return groups[i].NewestTimestamp.Before(groups[j].NewestTimestamp)
+		}
+		return groups[i].BuildVersion < groups[j].BuildVersion
 	})
 
 	return groups, nil
 }
 
+// newBuildGroup builds a BuildGroup of the flushes in dirs matching sel,
+// preferring flush manifests over file modification times, which do not
+// survive copies and downloads.
+func newBuildGroup(dirs []string, sel LabelSelector) (BuildGroup, error) {
+	g := BuildGroup{Dirs: dirs, Labels: sel}
+	var newestManifest time.Time
+	for _, dir := range dirs {
+		files, err := listSource(dir)
+		if err != nil {
+			return BuildGroup{}, err
+		}
+		manifests, err := readManifests(dir, files)
+		if err != nil {
+			return BuildGroup{}, err
+		}
+		ts := time.Time{}
+		for _, m := range manifests {
+			if !sel.matches(m) {
+				continue
+			}
+			if m.Timestamp.After(ts) {
+				ts = m.Timestamp
+			}
+			if !m.Timestamp.Before(newestManifest) {
+				newestManifest = m.Timestamp
+				g.BuildVersion = m.BuildVersion
+			}
+		}
+		if len(manifests) == 0 {
+			if ts, err = newestCounterTime([]string{dir}); err != nil {
+				return BuildGroup{}, err
+			}
+		}
+		if ts.After(g.NewestTimestamp) {
+			g.NewestTimestamp = ts
+		}
+	}
+	return g, nil
+}
+
 // newestCounterTime returns the most recent ModTime of covcounters.* files
-// across the given directories.
+// across the given directories. For bundles, the bundle's ModTime is used.
 func newestCounterTime(dirs []string) (time.Time, error) {
 	var newest time.Time
 	for _, dir := range dirs {
+		if isBundle(dir) {
+			info, err := os.Stat(dir)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("covparse: stat %s: %w", dir, err)
+			}
+			if info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+			continue
+		}
 		entries, err := os.ReadDir(dir)
 		if err != nil {
 			return time.Time{}, fmt.Errorf("covparse: read dir %s: %w", dir, err)
@@ -80,72 +168,99 @@ func newestCounterTime(dirs []string) (time.Time, error) {
 	return newest, nil
 }
 
-// FuncCoverage holds per-function coverage data extracted from `go tool covdata func`.
+// FuncCoverage holds per-function coverage data, equivalent to one line of
+// `go tool covdata func` output.
 type FuncCoverage struct {
 	FileName        string // e.g. "github.com/user/pkg/file.go"
 	FuncName        string // goreach (astmap) normalized: "(*Type).Method"
 	CoveragePercent float64
 }
 
-// RunCovdataFunc executes `go tool covdata func` on the given directories
-// and returns per-function coverage data with goreach-normalized function names.
-func RunCovdataFunc(dirs []string) ([]FuncCoverage, error) {
-	joined := strings.Join(dirs, ",")
-	cmd := exec.Command("go", "tool", "covdata", "func", "-i="+joined)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("covparse: go tool covdata func: %w\n%s", err, out)
-	}
-	return parseCovdataFuncOutput(string(out)), nil
+// ReadFuncCoverage decodes the coverage data in dirs and returns per-function
+// coverage data with goreach-normalized function names. Function literals
+// get no entry of their own, matching `go tool covdata func`.
+func ReadFuncCoverage(dirs []string) ([]FuncCoverage, error) {
+	return BuildGroup{Dirs: dirs}.FuncCoverage()
 }
 
-// parseCovdataFuncOutput parses the output of `go tool covdata func`.
-// Each line has the format: <file>:<line>: <funcname> <pct>%
-// The last line is a total line: "total (statements) <pct>%" which is skipped.
-func parseCovdataFuncOutput(output string) []FuncCoverage {
+// funcCoverage summarizes the merged units per function. Units are walked in
+// source order and a new function starts whenever the function name changes.
+func (d *coverageData) funcCoverage() []FuncCoverage {
 	var result []FuncCoverage
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "total") {
-			continue
+	for _, pkg := range d.sortedPackages() {
+		units := d.sortedUnits(pkg)
+		for i := 0; i < len(units); {
+			first := units[i]
+			var total, covered int
+			for ; i < len(units) && units[i].fn == first.fn; i++ {
+				total += int(units[i].numStmts)
+				if d.pkgs[pkg][units[i]] != 0 {
+					covered += int(units[i].numStmts)
+				}
+			}
+			if first.lit {
+				continue
+			}
+			if total == 0 {
+				total = 1
+			}
+			result = append(result, FuncCoverage{
+				FileName:        first.file,
+				FuncName:        NormalizeCovdataFuncName(first.fn),
+				CoveragePercent: float64(covered) / float64(total) * 100,
+			})
 		}
+	}
+	return result
+}
 
-		// Format: "github.com/user/pkg/file.go:42:\t\tFuncName\t\t75.0%"
-		// Split on tab to get fields
-		colonIdx := strings.Index(line, ":")
-		if colonIdx < 0 {
-			continue
-		}
-		fileName := line[:colonIdx]
-
-		// Find the function name and percentage
-		// After "file:line:", the rest is tab-separated: funcname and pct%
-		rest := line[colonIdx+1:]
-		// Skip the line number part (next colon)
-		colonIdx2 := strings.Index(rest, ":")
-		if colonIdx2 < 0 {
-			continue
-		}
-		rest = rest[colonIdx2+1:]
+// FuncRange is the source range of a function as recorded in coverage
+// meta-data: from the start of its first coverable unit to the end of its
+// last one. It starts at the function's first statement rather than at its
+// declaration, and covers the function literals inside it.
+type FuncRange struct {
+	FileName  string // e.g. "github.com/user/pkg/file.go"
+	FuncName  string // goreach (astmap) normalized: "(*Type).Method"
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+}
 
-		fields := strings.Fields(rest)
-		if len(fields) < 2 {
-			continue
-		}
-		funcName := fields[0]
-		pctStr := strings.TrimSuffix(fields[1], "%")
-		pct, err := strconv.ParseFloat(pctStr, 64)
-		if err != nil {
-			continue
+// MetaFuncRanges decodes the contents of a covmeta file and returns the
+// ranges of its functions, so that coverage can be attributed to functions
+// without the source code. Function literals and functions without
+// coverable units get no range of their own.
+func MetaFuncRanges(meta []byte) ([]FuncRange, error) {
+	mf, err := decodeMetaFile(meta)
+	if err != nil {
+		return nil, fmt.Errorf("covparse: decode meta-data: %w", err)
+	}
+	var result []FuncRange
+	for _, pkg := range mf.pkgs {
+		for _, fn := range pkg.funcs {
+			if fn.lit || len(fn.units) == 0 {
+				continue
+			}
+			r := FuncRange{FileName: fn.file, FuncName: NormalizeCovdataFuncName(fn.name)}
+			for i, u := range fn.units {
+				stLine, stCol, enLine, enCol := int(u.stLine), int(u.stCol), int(u.enLine), int(u.enCol)
+				if i == 0 || before(stLine, stCol, r.StartLine, r.StartCol) {
+					r.StartLine, r.StartCol = stLine, stCol
+				}
+				if i == 0 || before(r.EndLine, r.EndCol, enLine, enCol) {
+					r.EndLine, r.EndCol = enLine, enCol
+				}
+			}
+			result = append(result, r)
 		}
-
-		result = append(result, FuncCoverage{
-			FileName:        fileName,
-			FuncName:        NormalizeCovdataFuncName(funcName),
-			CoveragePercent: pct,
-		})
 	}
-	return result
+	return result, nil
+}
+
+// before reports whether line.col a is before line.col b.
+func before(aLine, aCol, bLine, bCol int) bool {
+	return aLine < bLine || aLine == bLine && aCol < bCol
 }
 
 // NormalizeCovdataFuncName converts `go tool covdata func` function name format
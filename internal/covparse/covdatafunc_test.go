@@ -26,63 +26,73 @@ func TestNormalizeCovdataFuncName(t *testing.T) {
 	}
 }
 
-func TestParseCovdataFuncOutput(t *testing.T) {
-	output := `github.com/user/pkg/handler.go:10:	HandleRequest	75.0%
-github.com/user/pkg/handler.go:25:	*Server.Start	100.0%
-github.com/user/pkg/handler.go:40:	Server.Stop	0.0%
-github.com/user/pkg/util.go:5:		Helper	50.0%
-total	(statements)	62.5%
-`
-	funcs := parseCovdataFuncOutput(output)
-
-	if len(funcs) != 4 {
-		t.Fatalf("expected 4 functions, got %d", len(funcs))
+func TestReadFuncCoverage(t *testing.T) {
+	funcs, err := ReadFuncCoverage([]string{"testdata/covdata"})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// Check first function
-	if funcs[0].FileName != "github.com/user/pkg/handler.go" {
-		t.Errorf("funcs[0].FileName = %q", funcs[0].FileName)
-	}
-	if funcs[0].FuncName != "HandleRequest" {
-		t.Errorf("funcs[0].FuncName = %q", funcs[0].FuncName)
-	}
-	if funcs[0].CoveragePercent != 75.0 {
-		t.Errorf("funcs[0].CoveragePercent = %v", funcs[0].CoveragePercent)
+	// Same functions and percentages as `go tool covdata func`; the function
+	// literal in main is folded into main.
+	want := []FuncCoverage{
+		{FileName: "example.com/covsample/main.go", FuncName: "(*Greeter).Greet", CoveragePercent: 100},
+		{FileName: "example.com/covsample/main.go", FuncName: "(Greeter).Name", CoveragePercent: 0},
+		{FileName: "example.com/covsample/main.go", FuncName: "classify", CoveragePercent: 75},
+		{FileName: "example.com/covsample/main.go", FuncName: "unused", CoveragePercent: 0},
+		{FileName: "example.com/covsample/main.go", FuncName: "main", CoveragePercent: 100},
+	}
+	if len(funcs) != len(want) {
+		t.Fatalf("expected %d functions, got %d: %+v", len(want), len(funcs), funcs)
+	}
+	for i := range want {
+		if funcs[i] != want[i] {
+			t.Errorf("funcs[%d] = %+v, want %+v", i, funcs[i], want[i])
+		}
 	}
+}
 
-	// Check pointer receiver
-	if funcs[1].FuncName != "(*Server).Start" {
-		t.Errorf("funcs[1].FuncName = %q, want (*Server).Start", funcs[1].FuncName)
-	}
-	if funcs[1].CoveragePercent != 100.0 {
-		t.Errorf("funcs[1].CoveragePercent = %v", funcs[1].CoveragePercent)
+func TestMetaFuncRanges(t *testing.T) {
+	meta, err := os.ReadFile("testdata/covdata/covmeta.3ce4e58830a5b292ef51542c36b6ae57")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	// Check value receiver
-	if funcs[2].FuncName != "(Server).Stop" {
-		t.Errorf("funcs[2].FuncName = %q, want (Server).Stop", funcs[2].FuncName)
+	ranges, err := MetaFuncRanges(meta)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// Check util.go file
-	if funcs[3].FileName != "github.com/user/pkg/util.go" {
-		t.Errorf("funcs[3].FileName = %q", funcs[3].FileName)
-	}
-	if funcs[3].FuncName != "Helper" {
-		t.Errorf("funcs[3].FuncName = %q", funcs[3].FuncName)
+	// The function literal in main (43.38,43.72) lies within main's range.
+	want := map[string]FuncRange{
+		"(*Greeter).Greet": {StartLine: 11, StartCol: 2, EndLine: 14, EndCol: 27},
+		"(Greeter).Name":   {StartLine: 17, StartCol: 34, EndLine: 17, EndCol: 49},
+		"classify":         {StartLine: 20, StartCol: 2, EndLine: 26, EndCol: 19},
+		"unused":           {StartLine: 30, StartCol: 2, EndLine: 34, EndCol: 10},
+		"main":             {StartLine: 38, StartCol: 2, EndLine: 44, EndCol: 17},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("expected %d ranges, got %d: %+v", len(want), len(ranges), ranges)
+	}
+	for _, r := range ranges {
+		w, ok := want[r.FuncName]
+		if !ok {
+			t.Errorf("unexpected function %q", r.FuncName)
+			continue
+		}
+		w.FileName, w.FuncName = "example.com/covsample/main.go", r.FuncName
+		if r != w {
+			t.Errorf("range = %+v, want %+v", r, w)
+		}
 	}
-}
 
-func TestParseCovdataFuncOutput_Empty(t *testing.T) {
-	funcs := parseCovdataFuncOutput("")
-	if len(funcs) != 0 {
-		t.Errorf("expected 0 functions for empty output, got %d", len(funcs))
+	if _, err := MetaFuncRanges([]byte("covmeta")); err == nil {
+		t.Error("expected error for truncated meta-data")
 	}
 }
 
-func TestParseCovdataFuncOutput_TotalOnly(t *testing.T) {
-	funcs := parseCovdataFuncOutput("total	(statements)	50.0%\n")
-	if len(funcs) != 0 {
-		t.Errorf("expected 0 functions for total-only output, got %d", len(funcs))
+func TestReadFuncCoverage_NoMetaData(t *testing.T) {
+	_, err := ReadFuncCoverage([]string{t.TempDir()})
+	if err == nil {
+		t.Fatal("expected error for directory without covmeta files")
 	}
 }
 
@@ -180,7 +190,7 @@ func TestParseDirRecursiveGrouped_Ordering(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	groups, err := ParseDirRecursiveGrouped(root)
+	groups, err := ParseDirRecursiveGrouped(root, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -204,3 +214,50 @@ func TestParseDirRecursiveGrouped_Ordering(t *testing.T) {
 		t.Errorf("expected second group to contain %s, got %v", dirB, groups[1].Dirs)
 	}
 }
+
+// TestParseDirRecursiveGrouped_Manifest tests that flush manifests take
+// precedence over covcounters ModTime when ordering groups.
+func TestParseDirRecursiveGrouped_Manifest(t *testing.T) {
+	root := t.TempDir()
+	dirA := filepath.Join(root, "a")
+	dirB := filepath.Join(root, "b")
+
+	// Build A's files are newer on disk (e.g. downloaded later), but its
+	// manifest says it was flushed before build B.
+	writeBuild := func(dir, hash, manifest string, mtime time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "covmeta."+hash), []byte("m"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		counter := filepath.Join(dir, "covcounters."+hash)
+		if err := os.WriteFile(counter, []byte("c"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(counter, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, manifestPrefix+hash+manifestSuffix), []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeBuild(dirA, "aaa", `{"timestamp":"2025-01-01T00:00:00Z","build_version":"v1"}`, time.Now())
+	writeBuild(dirB, "bbb", `{"timestamp":"2025-02-01T00:00:00Z","build_version":"v2"}`, time.Now().Add(-time.Hour))
+
+	groups, err := ParseDirRecursiveGrouped(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	if groups[0].BuildVersion != "v1" || groups[1].BuildVersion != "v2" {
+		t.Errorf("order = %s, %s; want v1, v2", groups[0].BuildVersion, groups[1].BuildVersion)
+	}
+	want := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	if !groups[1].NewestTimestamp.Equal(want) {
+		t.Errorf("NewestTimestamp = %v, want %v", groups[1].NewestTimestamp, want)
+	}
+}
@@ -2,37 +2,45 @@
 package covparse
 
 import (
+	"cmp"
 	"fmt"
+	"io"
 	"io/fs"
+	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+
+	"golang.org/x/tools/cover"
 )
 
 // ParseDir converts a single GOCOVERDIR directory to a text coverage profile.
-// It invokes `go tool covdata textfmt` under the hood.
+// The binary covmeta/covcounters files are decoded natively; no Go toolchain
+// is required.
 func ParseDir(dir string) (string, error) {
-	tmpFile, err := os.CreateTemp("", "goreach-profile-*.txt")
-	if err != nil {
-		return "", fmt.Errorf("covparse: create temp file: %w", err)
-	}
-	_ = tmpFile.Close()
+	return parseDirs([]string{dir}, nil)
+}
+
+// ReadProfiles decodes and merges the coverage data found in dirs and returns
+// it as cover.Profile values, equivalent to parsing the text profile that
+// ParseDir would produce.
+func ReadProfiles(dirs []string) ([]*cover.Profile, error) {
+	return readProfiles(dirs, nil)
+}
 
-	cmd := exec.Command("go", "tool", "covdata", "textfmt", "-i="+dir, "-o="+tmpFile.Name())
-	out, err := cmd.CombinedOutput()
+// readProfiles is ReadProfiles for the flushes in dirs that match sel.
+func readProfiles(dirs []string, sel LabelSelector) ([]*cover.Profile, error) {
+	text, err := parseDirs(dirs, sel)
 	if err != nil {
-		_ = os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("covparse: go tool covdata textfmt: %w\n%s", err, out)
+		return nil, err
 	}
-
-	data, err := os.ReadFile(tmpFile.Name())
-	_ = os.Remove(tmpFile.Name())
+	profiles, err := cover.ParseProfilesFromReader(strings.NewReader(text))
 	if err != nil {
-		return "", fmt.Errorf("covparse: read profile: %w", err)
+		return nil, fmt.Errorf("covparse: parse profile: %w", err)
 	}
-	return string(data), nil
+	return profiles, nil
 }
 
 // ParseDirRecursive walks dir recursively to find directories containing
@@ -63,7 +71,7 @@ func ParseDirRecursive(dir string) ([]string, error) {
 
 	var profiles []string
 	for _, k := range keys {
-		text, err := mergeAndParse(groups[k])
+		text, err := parseDirs(groups[k], nil)
 		if err != nil {
 			return nil, err
 		}
@@ -72,42 +80,284 @@ func ParseDirRecursive(dir string) ([]string, error) {
 	return profiles, nil
 }
 
-// mergeAndParse merges a set of coverage directories and returns the text profile.
-// If only one directory is provided, it parses directly without merging.
-func mergeAndParse(dirs []string) (string, error) {
-	if len(dirs) == 1 {
-		return ParseDir(dirs[0])
+// parseDirs merges the coverage data of one or more directories and returns
+// the text profile. Only flushes whose manifest matches sel are counted.
+func parseDirs(dirs []string, sel LabelSelector) (string, error) {
+	data, err := readDirs(dirs, sel)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := data.writeText(&sb); err != nil {
+		return "", fmt.Errorf("covparse: write profile: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// unitKey identifies a coverable unit within a package. Counts for the same
+// unit are accumulated across counter files, pods and directories.
+type unitKey struct {
+	file string
+	fn   string
+	lit  bool
+	coverUnit
+}
+
+// coverageData holds merged unit counts per package import path, mirroring
+// the way `go tool covdata` combines data from multiple runs.
+type coverageData struct {
+	mode string
+	pkgs map[string]map[unitKey]uint32
+	sel  LabelSelector // counter files whose manifest does not match are skipped
+}
+
+// readDirs decodes every covmeta file in dirs together with the covcounters
+// files that refer to it. Counter files without a matching meta file, or
+// whose flush manifest does not match sel, are ignored.
+func readDirs(dirs []string, sel LabelSelector) (*coverageData, error) {
+	d := &coverageData{pkgs: make(map[string]map[unitKey]uint32), sel: sel}
+	var metaFiles int
+	for _, dir := range dirs {
+		n, err := d.addDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		metaFiles += n
+	}
+	if metaFiles == 0 {
+		return nil, fmt.Errorf("covparse: no coverage meta-data found in %s", strings.Join(dirs, ","))
+	}
+	return d, nil
+}
+
+// addDir merges the coverage data of a single directory or bundle and
+// returns the number of meta files it contained. Bundles directly inside a
+// directory are merged as well.
+func (d *coverageData) addDir(dir string) (int, error) {
+	files, err := listSource(dir)
+	if err != nil {
+		return 0, err
+	}
+	if files, err = committedFiles(dir, files, true); err != nil {
+		return 0, err
+	}
+	var manifests map[string]*manifest
+	if len(d.sel) > 0 {
+		if manifests, err = readManifests(dir, files); err != nil {
+			return 0, err
+		}
+	}
+
+	metas := make(map[string]*metaFile)
+	var metaHashes []string
+	var counters []*counterFile
+	var bundleMetas int
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		switch {
+		case isBundle(f.name) && !isBundle(dir):
+			// Bundles are self-contained: their counters refer to their own meta.
+			n, err := d.addDir(path)
+			if err != nil {
+				return 0, err
+			}
+			bundleMetas += n
+		case strings.HasPrefix(f.name, "covmeta."):
+			mf, err := readSourceFile(f, path, decodeMetaFile)
+			if err != nil {
+				return 0, err
+			}
+			if d.mode != "" && d.mode != mf.mode {
+				return 0, fmt.Errorf("covparse: counter mode clash: %s uses %q, previously %q", path, mf.mode, d.mode)
+			}
+			d.mode = mf.mode
+			if _, dup := metas[mf.hash]; !dup {
+				metaHashes = append(metaHashes, mf.hash)
+			}
+			metas[mf.hash] = mf
+		case strings.HasPrefix(f.name, "covcounters."):
+			if len(d.sel) > 0 && !d.sel.matches(manifests[f.name]) {
+				continue
+			}
+			cf, err := readSourceFile(f, path, decodeCounterFile)
+			if err != nil {
+				return 0, err
+			}
+			counters = append(counters, cf)
+		}
+	}
+
+	d.merge(metaHashes, metas, counters)
+	return len(metas) + bundleMetas, nil
+}
+
+// merge adds the units of the meta files, in metaHashes order, and the
+// counts of the counter files that refer to them.
+func (d *coverageData) merge(metaHashes []string, metas map[string]*metaFile, counters []*counterFile) {
+	for _, hash := range metaHashes {
+		mf := metas[hash]
+		for _, pkg := range mf.pkgs {
+			for _, fn := range pkg.funcs {
+				for _, u := range fn.units {
+					d.add(pkg.path, fn, u, 0)
+				}
+			}
+		}
 	}
+	for _, cf := range counters {
+		mf, ok := metas[cf.metaHash]
+		if !ok {
+			continue
+		}
+		for _, fc := range cf.funcs {
+			if int(fc.pkgIdx) >= len(mf.pkgs) || int(fc.funcIdx) >= len(mf.pkgs[fc.pkgIdx].funcs) {
+				continue
+			}
+			pkg := mf.pkgs[fc.pkgIdx]
+			fn := pkg.funcs[fc.funcIdx]
+			for i, u := range fn.units {
+				var count uint32
+				switch {
+				case i < len(fc.counters):
+					count = fc.counters[i]
+				case mf.granularity == granularityPerFunc && len(fc.counters) > 0:
+					count = fc.counters[0]
+				}
+				d.add(pkg.path, fn, u, count)
+			}
+		}
+	}
+}
 
-	mergeDir, err := os.MkdirTemp("", "goreach-merge-*")
+// ParseData converts the in-memory contents of a covmeta file and of
+// covcounters files referring to it into a text coverage profile, as
+// ParseDir would for a directory holding them. It lets a process render
+// its own coverage from runtime/coverage.WriteMeta and WriteCounters.
+func ParseData(meta []byte, counters ...[]byte) (string, error) {
+	mf, err := decodeMetaFile(meta)
 	if err != nil {
-		return "", fmt.Errorf("covparse: create merge dir: %w", err)
+		return "", fmt.Errorf("covparse: decode meta-data: %w", err)
 	}
-	defer os.RemoveAll(mergeDir)
+	cfs := make([]*counterFile, 0, len(counters))
+	for _, c := range counters {
+		cf, err := decodeCounterFile(c)
+		if err != nil {
+			return "", fmt.Errorf("covparse: decode counters: %w", err)
+		}
+		cfs = append(cfs, cf)
+	}
+
+	d := &coverageData{mode: mf.mode, pkgs: make(map[string]map[unitKey]uint32)}
+	d.merge([]string{mf.hash}, map[string]*metaFile{mf.hash: mf}, cfs)
+	var sb strings.Builder
+	if err := d.writeText(&sb); err != nil {
+		return "", fmt.Errorf("covparse: write profile: %w", err)
+	}
+	return sb.String(), nil
+}
 
-	joined := strings.Join(dirs, ",")
-	cmd := exec.Command("go", "tool", "covdata", "merge", "-i="+joined, "-o="+mergeDir)
-	out, err := cmd.CombinedOutput()
+// readSourceFile reads f and decodes it with decode. path is used in errors.
+func readSourceFile[T any](f sourceFile, path string, decode func([]byte) (T, error)) (T, error) {
+	data, err := f.read()
+	if err != nil {
+		var zero T
+		return zero, fmt.Errorf("covparse: read %s: %w", path, err)
+	}
+	v, err := decode(data)
 	if err != nil {
-		return "", fmt.Errorf("covparse: go tool covdata merge: %w\n%s", err, out)
+		var zero T
+		return zero, fmt.Errorf("covparse: decode %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// add accumulates count into the unit's merged counter. In set mode counts
+// are OR-ed; otherwise they are summed with saturation.
+func (d *coverageData) add(pkgPath string, fn metaFunc, u coverUnit, count uint32) {
+	units := d.pkgs[pkgPath]
+	if units == nil {
+		units = make(map[unitKey]uint32)
+		d.pkgs[pkgPath] = units
+	}
+	key := unitKey{file: fn.file, fn: fn.name, lit: fn.lit, coverUnit: u}
+	prev := units[key]
+	switch {
+	case d.mode == "set":
+		if prev != 0 || count != 0 {
+			units[key] = 1
+		} else {
+			units[key] = 0
+		}
+	case prev > math.MaxUint32-count:
+		units[key] = math.MaxUint32
+	default:
+		units[key] = prev + count
 	}
+}
 
-	return ParseDir(mergeDir)
+// sortedPackages returns the package import paths in sorted order.
+func (d *coverageData) sortedPackages() []string {
+	pkgs := make([]string, 0, len(d.pkgs))
+	for p := range d.pkgs {
+		pkgs = append(pkgs, p)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// sortedUnits returns the units of a package ordered by source position.
+func (d *coverageData) sortedUnits(pkgPath string) []unitKey {
+	units := make([]unitKey, 0, len(d.pkgs[pkgPath]))
+	for u := range d.pkgs[pkgPath] {
+		units = append(units, u)
+	}
+	slices.SortFunc(units, func(a, b unitKey) int {
+		return cmp.Or(
+			strings.Compare(a.file, b.file),
+			cmp.Compare(a.stLine, b.stLine),
+			cmp.Compare(a.enLine, b.enLine),
+			cmp.Compare(a.stCol, b.stCol),
+			cmp.Compare(a.enCol, b.enCol),
+			cmp.Compare(a.numStmts, b.numStmts),
+		)
+	})
+	return units
+}
+
+// writeText writes the merged data in the text profile format produced by
+// `go tool covdata textfmt`.
+func (d *coverageData) writeText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "mode: %s\n", d.mode); err != nil {
+		return err
+	}
+	for _, pkg := range d.sortedPackages() {
+		for _, u := range d.sortedUnits(pkg) {
+			if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n",
+				u.file, u.stLine, u.stCol, u.enLine, u.enCol, u.numStmts, d.pkgs[pkg][u]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // groupByMetaHash groups coverage directories by their covmeta hash set.
 // Each directory's identity is the sorted set of covmeta.<hash> filenames it
 // contains. Directories sharing the same hash set belong to the same build.
+// Bundles are grouped by the covmeta files inside them.
 func groupByMetaHash(dirs []string) (map[string][]string, error) {
 	groups := make(map[string][]string)
 	for _, dir := range dirs {
-		entries, err := os.ReadDir(dir)
+		files, err := listSource(dir)
 		if err != nil {
-			return nil, fmt.Errorf("covparse: read dir %s: %w", dir, err)
+			return nil, err
+		}
+		if files, err = committedFiles(dir, files, false); err != nil {
+			return nil, err
 		}
 		var hashes []string
-		for _, e := range entries {
-			if hash, ok := strings.CutPrefix(e.Name(), "covmeta."); ok {
+		for _, f := range files {
+			if hash, ok := strings.CutPrefix(f.name, "covmeta."); ok {
 				hashes = append(hashes, hash)
 			}
 		}
@@ -127,9 +377,13 @@ func ParseProfileFile(path string) (string, error) {
 	return string(data), nil
 }
 
-// findCoverageDirs walks root and returns directories that contain coverage data files.
+// findCoverageDirs walks root and returns directories that contain coverage
+// data files, as well as bundle files, which are coverage sources of their own.
+// If any directory under root has commit markers, directories without one
+// are skipped with a warning: they hold flushes that never completed.
 func findCoverageDirs(root string) ([]string, error) {
 	seen := make(map[string]bool)
+	marked := make(map[string]bool)
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -138,11 +392,14 @@ func findCoverageDirs(root string) ([]string, error) {
 			return nil
 		}
 		name := d.Name()
-		if strings.HasPrefix(name, "covmeta.") || strings.HasPrefix(name, "covcounters.") {
-			dir := filepath.Dir(path)
-			if !seen[dir] {
-				seen[dir] = true
-			}
+		switch {
+		case isBundle(name):
+			seen[path] = true
+			marked[path] = true // bundles are uploaded atomically
+		case isCommitMarker(name):
+			marked[filepath.Dir(path)] = true
+		case strings.HasPrefix(name, "covmeta."), strings.HasPrefix(name, "covcounters."):
+			seen[filepath.Dir(path)] = true
 		}
 		return nil
 	})
@@ -150,8 +407,16 @@ func findCoverageDirs(root string) ([]string, error) {
 		return nil, fmt.Errorf("covparse: walk %s: %w", root, err)
 	}
 
+	hasMarkers := false
+	for dir := range marked {
+		hasMarkers = hasMarkers || !isBundle(dir)
+	}
 	dirs := make([]string, 0, len(seen))
 	for d := range seen {
+		if hasMarkers && !marked[d] {
+			Warnf("%s: skipping directory without commit marker", d)
+			continue
+		}
 		dirs = append(dirs, d)
 	}
 	return dirs, nil
@@ -278,3 +278,155 @@ func TestParseDirRecursive_NoCoverageFiles(t *testing.T) {
 		t.Errorf("error should mention 'no coverage data found', got: %v", err)
 	}
 }
+
+// TestParseDir_Native tests that ParseDir decodes real covmeta/covcounters
+// files into the same text profile as `go tool covdata textfmt`.
+// testdata/covdata was produced by two runs of a small -covermode=atomic
+// binary; testdata/covdata.txt is the matching textfmt output.
+func TestParseDir_Native(t *testing.T) {
+	want, err := os.ReadFile("testdata/covdata.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseDir("testdata/covdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("ParseDir() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseData(t *testing.T) {
+	want, err := os.ReadFile("testdata/covdata.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) []byte {
+		b, err := os.ReadFile(filepath.Join("testdata/covdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	got, err := ParseData(read("covmeta.3ce4e58830a5b292ef51542c36b6ae57"),
+		read("covcounters.3ce4e58830a5b292ef51542c36b6ae57.6591.1792124581563684758"),
+		read("covcounters.3ce4e58830a5b292ef51542c36b6ae57.6594.1792124581564980415"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("ParseData() =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := ParseData([]byte("covmeta")); err == nil {
+		t.Error("expected error for truncated meta-data")
+	}
+}
+
+func TestReadProfiles(t *testing.T) {
+	profiles, err := ReadProfiles([]string{"testdata/covdata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 {
+		t.Fatalf("expected 1 profile, got %d", len(profiles))
+	}
+	p := profiles[0]
+	if p.FileName != "example.com/covsample/main.go" {
+		t.Errorf("FileName = %q", p.FileName)
+	}
+	if p.Mode != "atomic" {
+		t.Errorf("Mode = %q, want atomic", p.Mode)
+	}
+	if len(p.Blocks) != 16 {
+		t.Fatalf("expected 16 blocks, got %d", len(p.Blocks))
+	}
+	// Counts from both runs are summed.
+	if b := p.Blocks[0]; b.StartLine != 11 || b.Count != 2 {
+		t.Errorf("Blocks[0] = %+v, want line 11 with count 2", b)
+	}
+}
+
+// TestReadProfiles_MergeDirs tests that counters are summed across
+// directories that share the same covmeta.
+func TestReadProfiles_MergeDirs(t *testing.T) {
+	root := t.TempDir()
+	entries, err := os.ReadDir("testdata/covdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{filepath.Join(root, "pod-1"), filepath.Join(root, "pod-2")}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join("testdata/covdata", e.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(d, e.Name()), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	profiles, err := ReadProfiles(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := profiles[0].Blocks[0]; b.Count != 4 {
+		t.Errorf("Blocks[0].Count = %d, want 4", b.Count)
+	}
+}
+
+// TestParseDir_IgnoresOrphanCounters tests that counter files without a
+// matching covmeta file are skipped.
+func TestParseDir_IgnoresOrphanCounters(t *testing.T) {
+	dir := t.TempDir()
+	meta, err := os.ReadFile("testdata/covdata/covmeta.3ce4e58830a5b292ef51542c36b6ae57")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "covmeta.3ce4e58830a5b292ef51542c36b6ae57"), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(got), "\n")[1:] {
+		if !strings.HasSuffix(line, " 0") {
+			t.Errorf("expected zero count without counter files, got %q", line)
+		}
+	}
+}
+
+func TestDecodeCounterFile_Truncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/covdata/covcounters.3ce4e58830a5b292ef51542c36b6ae57.6591.1792124581563684758")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every truncation must fail cleanly instead of panicking.
+	for n := range len(data) - 1 {
+		if _, err := decodeCounterFile(data[:n]); err == nil {
+			t.Errorf("decodeCounterFile(%d bytes): expected error", n)
+		}
+	}
+}
+
+func TestDecodeMetaFile_Truncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/covdata/covmeta.3ce4e58830a5b292ef51542c36b6ae57")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(data) - 1 {
+		if _, err := decodeMetaFile(data[:n]); err == nil {
+			t.Errorf("decodeMetaFile(%d bytes): expected error", n)
+		}
+	}
+}
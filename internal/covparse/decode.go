@@ -0,0 +1,346 @@
+package covparse
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/yag13s/goreach/internal/covmeta"
+)
+
+// This file decodes the binary coverage data files written by binaries built
+// with -cover (see internal/coverage in the Go distribution). Only the parts
+// needed to reconstruct text profiles are decoded: package paths, function
+// descriptors with their coverable units, and per-function counters.
+
+var counterMagic = [4]byte{0x00, 'c', 'w', 'm'}
+
+const (
+	counterFileVersion = 1
+
+	metaSymbolHeaderSize = 44 // MetaSymbolHeader (CovMetaHeaderSize)
+	counterHeaderSize    = 32 // CounterFileHeader
+	counterFooterSize    = 16 // CounterFileFooter
+
+	granularityPerFunc = 2
+
+	flavorRaw     = 1
+	flavorULeb128 = 2
+)
+
+// metaFile is a decoded covmeta.<hash> file.
+type metaFile struct {
+	hash        string // hex-encoded meta-data file hash
+	mode        string // "set", "count" or "atomic"
+	granularity byte
+	pkgs        []metaPackage
+}
+
+// metaPackage holds the coverage meta-data of a single instrumented package.
+type metaPackage struct {
+	path  string
+	funcs []metaFunc
+}
+
+// metaFunc describes one function (or function literal) and its coverable units.
+type metaFunc struct {
+	name  string
+	file  string
+	lit   bool
+	units []coverUnit
+}
+
+// coverUnit is a coverable source range, equivalent to a text profile block.
+type coverUnit struct {
+	stLine, stCol uint32
+	enLine, enCol uint32
+	numStmts      uint32
+}
+
+// funcCounters is the counter payload of a single function in a counter file.
+type funcCounters struct {
+	pkgIdx   uint32
+	funcIdx  uint32
+	counters []uint32
+}
+
+// counterFile is a decoded covcounters.<hash>.<pid>.<nanotime> file.
+type counterFile struct {
+	metaHash string // hex-encoded hash of the meta-data file it refers to
+	funcs    []funcCounters
+}
+
+func decodeMetaFile(data []byte) (*metaFile, error) {
+	h, err := covmeta.ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	entries := h.Entries
+	if entries > uint64(len(data))/16 {
+		return nil, fmt.Errorf("invalid package count %d", entries)
+	}
+
+	r := &byteReader{b: data, off: covmeta.HeaderLen}
+	offsets := make([]uint64, entries)
+	for i := range offsets {
+		offsets[i] = r.u64()
+	}
+	lengths := make([]uint64, entries)
+	for i := range lengths {
+		lengths[i] = r.u64()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	mf := &metaFile{
+		hash:        h.Hash,
+		mode:        h.Mode,
+		granularity: h.Granularity,
+		pkgs:        make([]metaPackage, 0, entries),
+	}
+	for i := range offsets {
+		off, n := offsets[i], lengths[i]
+		if off > uint64(len(data)) || n > uint64(len(data))-off {
+			return nil, fmt.Errorf("package %d: payload out of range", i)
+		}
+		pkg, err := decodeMetaPackage(data[off : off+n])
+		if err != nil {
+			return nil, fmt.Errorf("package %d: %w", i, err)
+		}
+		mf.pkgs = append(mf.pkgs, pkg)
+	}
+	return mf, nil
+}
+
+// decodeMetaPackage decodes a single package meta-data blob.
+func decodeMetaPackage(payload []byte) (metaPackage, error) {
+	r := &byteReader{b: payload}
+	r.u32() // length
+	r.u32() // package name
+	pkgPathIdx := r.u32()
+	r.u32()         // module path
+	r.bytes(16 + 4) // meta hash, flags and padding
+	r.u32()         // number of files
+	numFuncs := r.u32()
+	if r.err != nil {
+		return metaPackage{}, r.err
+	}
+	if uint64(numFuncs)*4 > uint64(len(payload)) {
+		return metaPackage{}, fmt.Errorf("invalid function count %d", numFuncs)
+	}
+
+	r.off = metaSymbolHeaderSize + 4*int(numFuncs)
+	strs := r.stringTable()
+	if r.err != nil {
+		return metaPackage{}, r.err
+	}
+	str := func(idx uint64) string {
+		if idx >= uint64(len(strs)) {
+			r.fail(fmt.Errorf("string index %d out of range", idx))
+			return ""
+		}
+		return strs[idx]
+	}
+
+	pkg := metaPackage{path: str(uint64(pkgPathIdx)), funcs: make([]metaFunc, 0, numFuncs)}
+	for i := range int(numFuncs) {
+		r.off = metaSymbolHeaderSize + 4*i
+		r.off = int(r.u32())
+		numUnits := r.uleb()
+		fn := metaFunc{name: str(r.uleb()), file: str(r.uleb())}
+		if r.err != nil {
+			return metaPackage{}, r.err
+		}
+		if numUnits > uint64(len(payload)) {
+			return metaPackage{}, fmt.Errorf("invalid unit count %d", numUnits)
+		}
+		fn.units = make([]coverUnit, 0, numUnits)
+		for range numUnits {
+			fn.units = append(fn.units, coverUnit{
+				stLine:   uint32(r.uleb()),
+				stCol:    uint32(r.uleb()),
+				enLine:   uint32(r.uleb()),
+				enCol:    uint32(r.uleb()),
+				numStmts: uint32(r.uleb()),
+			})
+		}
+		fn.lit = r.uleb() != 0
+		if r.err != nil {
+			return metaPackage{}, r.err
+		}
+		pkg.funcs = append(pkg.funcs, fn)
+	}
+	return pkg, nil
+}
+
+func decodeCounterFile(data []byte) (*counterFile, error) {
+	if len(data) < counterHeaderSize+counterFooterSize {
+		return nil, errors.New("file too short")
+	}
+	r := &byteReader{b: data}
+	var magic [4]byte
+	copy(magic[:], r.bytes(4))
+	version := r.u32()
+	hash := r.bytes(16)
+	flavor := r.u8()
+	bigEndian := r.u8() != 0
+	if magic != counterMagic {
+		return nil, errors.New("invalid counter data file magic")
+	}
+	if version > counterFileVersion {
+		return nil, fmt.Errorf("unsupported counter data file version %d", version)
+	}
+
+	footer := &byteReader{b: data, off: len(data) - counterFooterSize}
+	copy(magic[:], footer.bytes(4))
+	footer.u32() // padding
+	numSegments := footer.u32()
+	if magic != counterMagic {
+		return nil, errors.New("invalid counter data file footer")
+	}
+
+	var readCounter func() uint32
+	switch flavor {
+	case flavorULeb128:
+		readCounter = func() uint32 { return uint32(r.uleb()) }
+	case flavorRaw:
+		order := binary.ByteOrder(binary.LittleEndian)
+		if bigEndian {
+			order = binary.BigEndian
+		}
+		readCounter = func() uint32 {
+			b := r.bytes(4)
+			if b == nil {
+				return 0
+			}
+			return order.Uint32(b)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported counter flavor %d", flavor)
+	}
+
+	cf := &counterFile{metaHash: hex.EncodeToString(hash)}
+	r.off = counterHeaderSize
+	for range numSegments {
+		numFuncs := r.u64()
+		strTabLen := r.u32()
+		argsLen := r.u32()
+		r.bytes(int(strTabLen) + int(argsLen))
+		if rem := r.off % 4; rem != 0 {
+			r.bytes(4 - rem)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if numFuncs > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid function count %d", numFuncs)
+		}
+		for range numFuncs {
+			n := readCounter()
+			fc := funcCounters{pkgIdx: readCounter(), funcIdx: readCounter()}
+			if r.err != nil {
+				return nil, r.err
+			}
+			if uint64(n) > uint64(len(data)) {
+				return nil, fmt.Errorf("invalid counter count %d", n)
+			}
+			fc.counters = make([]uint32, n)
+			for i := range fc.counters {
+				fc.counters[i] = readCounter()
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			cf.funcs = append(cf.funcs, fc)
+		}
+		r.bytes(counterFooterSize)
+	}
+	return cf, nil
+}
+
+// byteReader reads little-endian values from a byte slice. The first
+// out-of-range read records an error in err; later reads return zero values.
+type byteReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *byteReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off < 0 || r.off > len(r.b) || n > len(r.b)-r.off {
+		r.fail(errors.New("unexpected end of data"))
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *byteReader) u8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *byteReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *byteReader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *byteReader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		b := r.bytes(1)
+		if b == nil {
+			return 0
+		}
+		if shift >= 64 {
+			r.fail(errors.New("uleb128 value overflows"))
+			return 0
+		}
+		v |= uint64(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+// stringTable reads a ULEB128-prefixed table of length-prefixed strings.
+func (r *byteReader) stringTable() []string {
+	n := r.uleb()
+	if n > uint64(len(r.b)) {
+		r.fail(fmt.Errorf("invalid string table size %d", n))
+		return nil
+	}
+	strs := make([]string, 0, n)
+	for range n {
+		l := r.uleb()
+		if l > uint64(len(r.b)) {
+			r.fail(fmt.Errorf("invalid string length %d", l))
+			return nil
+		}
+		strs = append(strs, string(r.bytes(int(l))))
+	}
+	return strs
+}
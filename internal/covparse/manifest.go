@@ -0,0 +1,133 @@
+package covparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Flush manifests are the JSON files the flush SDK writes next to every
+// flush's covmeta/covcounters files, named after the counters file they
+// describe (flush.ManifestName): covcounters.<hash>.<pid>.<nanos> is
+// described by goreach-manifest.<hash>.<pid>.<nanos>.json.
+const (
+	manifestPrefix = "goreach-manifest."
+	manifestSuffix = ".json"
+)
+
+// manifest is the subset of flush.Metadata that covparse uses.
+type manifest struct {
+	Timestamp    time.Time `json:"timestamp"`
+	BuildVersion string    `json:"build_version"`
+	ServiceName  string    `json:"service_name"`
+
+	Labels map[string]string `json:"labels"`
+}
+
+// readManifests parses the flush manifests among files, the files of the
+// coverage source dir, keyed by the name of the covcounters file they
+// describe. It returns an empty map if the source has no manifest.
+func readManifests(dir string, files []sourceFile) (map[string]*manifest, error) {
+	manifests := make(map[string]*manifest)
+	for _, f := range files {
+		rest, ok := strings.CutPrefix(f.name, manifestPrefix)
+		if !ok {
+			continue
+		}
+		id, ok := strings.CutSuffix(rest, manifestSuffix)
+		if !ok || id == "" {
+			continue
+		}
+		path := filepath.Join(dir, f.name)
+		data, err := f.read()
+		if err != nil {
+			return nil, fmt.Errorf("covparse: read manifest %s: %w", path, err)
+		}
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("covparse: parse manifest %s: %w", path, err)
+		}
+		manifests["covcounters."+id] = &m
+	}
+	return manifests, nil
+}
+
+// LabelSelector selects coverage directories by the labels recorded in their
+// flush manifest. A directory matches if it has every selector label with
+// the same value; directories without a manifest never match a non-empty
+// selector.
+type LabelSelector map[string]string
+
+// ParseLabelSelector parses a selector of the form "name=value[,name=value...]".
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	sel := make(LabelSelector)
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("covparse: invalid label selector %q: want name=value", part)
+		}
+		sel[name] = value
+	}
+	return sel, nil
+}
+
+// String returns the selector in the form accepted by ParseLabelSelector,
+// sorted by label name.
+func (s LabelSelector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, k := range slices.Sorted(maps.Keys(s)) {
+		parts = append(parts, k+"="+s[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s LabelSelector) matches(m *manifest) bool {
+	if len(s) == 0 {
+		return true
+	}
+	if m == nil {
+		return false
+	}
+	for k, v := range s {
+		if got, ok := m.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectDirs returns the directories in dirs holding a flush whose manifest
+// matches sel. An empty selector selects every directory. Directories shared
+// by several processes may also hold flushes that do not match;
+// BuildGroup.Labels skips those.
+func SelectDirs(dirs []string, sel LabelSelector) ([]string, error) {
+	if len(sel) == 0 {
+		return dirs, nil
+	}
+	var selected []string
+	for _, dir := range dirs {
+		files, err := listSource(dir)
+		if err != nil {
+			return nil, err
+		}
+		manifests, err := readManifests(dir, files)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range manifests {
+			if sel.matches(m) {
+				selected = append(selected, dir)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
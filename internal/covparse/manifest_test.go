@@ -0,0 +1,127 @@
+package covparse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	sel, err := ParseLabelSelector("region=eu, cohort=canary,,tenant=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sel.String(), "cohort=canary,region=eu,tenant="; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"region", "=eu"} {
+		if _, err := ParseLabelSelector(bad); err == nil {
+			t.Errorf("ParseLabelSelector(%q) should fail", bad)
+		}
+	}
+}
+
+// TestSelectDirs tests that only directories whose manifest has all
+// selector labels are selected, and that an empty selector selects all.
+func TestSelectDirs(t *testing.T) {
+	root := t.TempDir()
+	manifests := map[string]string{
+		"eu-canary": `{"labels":{"region":"eu","cohort":"canary"}}`,
+		"eu-stable": `{"labels":{"region":"eu","cohort":"stable"}}`,
+		"us-canary": `{"labels":{"region":"us","cohort":"canary"}}`,
+		"none":      "",
+	}
+	var dirs []string
+	for name, m := range manifests {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if m != "" {
+			if err := os.WriteFile(filepath.Join(dir, manifestPrefix+"h.1.1"+manifestSuffix), []byte(m), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+
+	got, err := SelectDirs(dirs, LabelSelector{"region": "eu", "cohort": "canary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || filepath.Base(got[0]) != "eu-canary" {
+		t.Errorf("SelectDirs = %v, want [eu-canary]", got)
+	}
+
+	got, err = SelectDirs(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(dirs) {
+		t.Errorf("empty selector selected %d of %d dirs", len(got), len(dirs))
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "none", manifestPrefix+"h.1.1"+manifestSuffix), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SelectDirs(dirs, LabelSelector{"region": "eu"}); err == nil || !strings.Contains(err.Error(), "parse manifest") {
+		t.Errorf("expected manifest parse error, got %v", err)
+	}
+}
+
+// TestBuildGroup_LabelsPerFlush tests that in a directory shared by
+// processes with different labels only the counters of matching flushes
+// are merged.
+func TestBuildGroup_LabelsPerFlush(t *testing.T) {
+	dir := t.TempDir()
+	entries, err := os.ReadDir("testdata/covdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metaData, euCounters []byte
+	region := "eu"
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join("testdata/covdata", e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		id, ok := strings.CutPrefix(e.Name(), "covcounters.")
+		if !ok {
+			metaData = data
+			continue
+		}
+		if region == "eu" {
+			euCounters = data
+		}
+		m := `{"labels":{"region":"` + region + `"}}`
+		if err := os.WriteFile(filepath.Join(dir, manifestPrefix+id+manifestSuffix), []byte(m), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		region = "us"
+	}
+
+	sel := LabelSelector{"region": "eu"}
+	dirs, err := SelectDirs([]string{dir}, sel)
+	if err != nil || len(dirs) != 1 {
+		t.Fatalf("SelectDirs = %v, %v; want the shared dir", dirs, err)
+	}
+	got, err := BuildGroup{Dirs: dirs, Labels: sel}.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParseData(metaData, euCounters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("profile for %s:\n%s\nwant only the eu flush:\n%s", sel, got, want)
+	}
+	if all, _ := ParseDir(dir); all == want {
+		t.Fatal("test data does not tell the flushes apart")
+	}
+}
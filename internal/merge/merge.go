@@ -4,6 +4,7 @@ package merge
 
 import (
 	"fmt"
+	"maps"
 	"math"
 	"time"
 
@@ -80,6 +81,7 @@ func Merge(reports []*report.Report) (*report.Report, error) {
 		Version:     base.Version,
 		GeneratedAt: time.Now().UTC(),
 		Mode:        "merged",
+		Labels:      commonLabels(reports),
 		Packages:    make([]report.PackageReport, len(base.Packages)),
 	}
 
@@ -174,6 +176,17 @@ func recomputeStats(r *report.Report) {
 	}
 }
 
+// commonLabels returns the label selection shared by all reports, or nil if
+// the reports were restricted to different labels.
+func commonLabels(reports []*report.Report) map[string]string {
+	for _, r := range reports[1:] {
+		if !maps.Equal(r.Labels, reports[0].Labels) {
+			return nil
+		}
+	}
+	return maps.Clone(reports[0].Labels)
+}
+
 // deepCopy returns a deep copy of the report so the caller can mutate it
 // without affecting the original.
 func deepCopy(src *report.Report) *report.Report {
@@ -181,6 +194,7 @@ func deepCopy(src *report.Report) *report.Report {
 		Version:     src.Version,
 		GeneratedAt: src.GeneratedAt,
 		Mode:        src.Mode,
+		Labels:      maps.Clone(src.Labels),
 		Total:       src.Total,
 		Packages:    make([]report.PackageReport, len(src.Packages)),
 	}
@@ -474,3 +474,29 @@ func TestMerge_SingleReport_NoLatestBlocks(t *testing.T) {
 		t.Errorf("UnreachedBlocks len = %d, want 1", len(foo.UnreachedBlocks))
 	}
 }
+
+// TestMergeLabels tests that a label selection is kept only if all inputs
+// share it.
+func TestMergeLabels(t *testing.T) {
+	r1 := makeReport(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), map[string]float64{"Foo": 10})
+	r2 := makeReport(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), map[string]float64{"Foo": 20})
+	r1.Labels = map[string]string{"region": "eu"}
+	r2.Labels = map[string]string{"region": "eu"}
+
+	merged, err := Merge([]*report.Report{r1, r2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Labels["region"] != "eu" {
+		t.Errorf("Labels = %v, want region=eu", merged.Labels)
+	}
+
+	r2.Labels = map[string]string{"region": "us"}
+	merged, err = Merge([]*report.Report{r1, r2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Labels != nil {
+		t.Errorf("Labels = %v, want nil for differing selections", merged.Labels)
+	}
+}
@@ -10,11 +10,12 @@ import (
 
 // Report is the top-level JSON output of goreach analyze.
 type Report struct {
-	Version     int             `json:"version"`
-	GeneratedAt time.Time       `json:"generated_at"`
-	Mode        string          `json:"mode"`
-	Total       CoverageStats   `json:"total"`
-	Packages    []PackageReport `json:"packages"`
+	Version     int               `json:"version"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Mode        string            `json:"mode"`
+	Labels      map[string]string `json:"labels,omitempty"` // label selector the report was restricted to
+	Total       CoverageStats     `json:"total"`
+	Packages    []PackageReport   `json:"packages"`
 }
 
 // CoverageStats holds aggregate coverage statistics.